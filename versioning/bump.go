@@ -0,0 +1,60 @@
+package versioning
+
+// bumpPrecedence ranks the bump types when reports are combined, from the
+// weakest to the strongest:
+//
+//   - BumpNone, or an empty or unknown bump type, changes nothing.
+//   - BumpPrerelease only advances the prerelease identifier, so any other
+//     bump outranks it.
+//   - BumpGraduate releases the current prerelease as a stable version,
+//     which is all a prerelease bump could ask for.
+//   - BumpPatch, BumpMinor and BumpMajor follow semver. They outrank
+//     BumpGraduate, which is kept alongside them in BumpResolution.GraduateKey:
+//     whether the prerelease being graduated already covers them depends on
+//     the previous version (see MergedVersionReport.NextVersion).
+//   - BumpCustom means a version was chosen explicitly, which overrides
+//     anything computed from the other bump types.
+var bumpPrecedence = map[BumpType]int{
+	BumpNone:       0,
+	BumpPrerelease: 1,
+	BumpGraduate:   2,
+	BumpPatch:      3,
+	BumpMinor:      4,
+	BumpMajor:      5,
+	BumpCustom:     6,
+}
+
+// BumpResolution is the bump type resolved across several reports.
+type BumpResolution struct {
+	BumpType BumpType
+	// Key is the key of the report that decided BumpType, or empty if no
+	// report asked for a bump.
+	Key string
+	// GraduateKey is the key of a report asking for BumpGraduate when
+	// BumpType is BumpMajor, BumpMinor or BumpPatch, in which case both the
+	// graduation and the bump apply. It is empty otherwise.
+	GraduateKey string
+}
+
+// EffectiveBumpType resolves the release bump across all reports, using the
+// precedence custom > major > minor > patch > graduate > prerelease > none.
+// When several reports share the winning bump type, the first of them in
+// m.Reports, that is the one with the highest priority, decides. A graduation
+// requested along with a standard bump is reported in GraduateKey.
+func (m *MergedVersionReport) EffectiveBumpType() BumpResolution {
+	resolution := BumpResolution{BumpType: BumpNone}
+	graduateKey := ""
+	for _, report := range m.Reports {
+		if report.BumpType == BumpGraduate && len(graduateKey) == 0 {
+			graduateKey = report.Key
+		}
+		if bumpPrecedence[report.BumpType] > bumpPrecedence[resolution.BumpType] {
+			resolution = BumpResolution{BumpType: report.BumpType, Key: report.Key}
+		}
+	}
+	switch resolution.BumpType {
+	case BumpMajor, BumpMinor, BumpPatch:
+		resolution.GraduateKey = graduateKey
+	}
+	return resolution
+}
@@ -0,0 +1,157 @@
+package versioning
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveBumpType(t *testing.T) {
+	tests := []struct {
+		name     string
+		reports  []VersionReport
+		expected BumpResolution
+	}{
+		{
+			name:     "no reports",
+			expected: BumpResolution{BumpType: BumpNone},
+		},
+		{
+			name: "no bump",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpNone},
+				{Key: "b", BumpType: ""},
+			},
+			expected: BumpResolution{BumpType: BumpNone},
+		},
+		{
+			name: "major wins over minor and patch",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpPatch},
+				{Key: "b", BumpType: BumpMajor},
+				{Key: "c", BumpType: BumpMinor},
+			},
+			expected: BumpResolution{BumpType: BumpMajor, Key: "b"},
+		},
+		{
+			name: "prerelease is outranked by patch",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpPrerelease},
+				{Key: "b", BumpType: BumpPatch},
+			},
+			expected: BumpResolution{BumpType: BumpPatch, Key: "b"},
+		},
+		{
+			name: "prerelease wins over none",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpNone},
+				{Key: "b", BumpType: BumpPrerelease},
+			},
+			expected: BumpResolution{BumpType: BumpPrerelease, Key: "b"},
+		},
+		{
+			name: "major wins over graduate, which is kept",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpGraduate},
+				{Key: "b", BumpType: BumpMajor},
+			},
+			expected: BumpResolution{BumpType: BumpMajor, Key: "b", GraduateKey: "a"},
+		},
+		{
+			name: "graduate wins over prerelease",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpPrerelease},
+				{Key: "b", BumpType: BumpGraduate},
+			},
+			expected: BumpResolution{BumpType: BumpGraduate, Key: "b"},
+		},
+		{
+			name: "custom wins over everything",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpGraduate},
+				{Key: "b", BumpType: BumpCustom},
+				{Key: "c", BumpType: BumpMajor},
+			},
+			expected: BumpResolution{BumpType: BumpCustom, Key: "b"},
+		},
+		{
+			name: "first report decides ties",
+			reports: []VersionReport{
+				{Key: "a", BumpType: BumpMinor},
+				{Key: "b", BumpType: BumpMinor},
+			},
+			expected: BumpResolution{BumpType: BumpMinor, Key: "a"},
+		},
+		{
+			name: "unknown bump types are ignored",
+			reports: []VersionReport{
+				{Key: "a", BumpType: "experimental"},
+				{Key: "b", BumpType: BumpPatch},
+			},
+			expected: BumpResolution{BumpType: BumpPatch, Key: "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := &MergedVersionReport{Reports: tt.reports}
+			assert.Equal(t, tt.expected, merged.EffectiveBumpType())
+		})
+	}
+}
+
+func TestEffectiveBumpTypeFollowsPriority(t *testing.T) {
+	merged, err := mergeVersionReports([]VersionReport{
+		{Key: "low", Priority: 1, BumpType: BumpMinor},
+		{Key: "high", Priority: 5, BumpType: BumpMinor},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, BumpResolution{BumpType: BumpMinor, Key: "high"}, merged.EffectiveBumpType())
+}
+
+func TestNextVersionWithGraduation(t *testing.T) {
+	tests := []struct {
+		bump     BumpType
+		previous string
+		expected string
+	}{
+		// On a stable version graduation changes nothing, so the bump applies.
+		{BumpMajor, "1.2.3", "2.0.0"},
+		{BumpMinor, "1.2.3", "1.3.0"},
+		{BumpPatch, "1.2.3", "1.2.4"},
+		{BumpMajor, "0.2.3", "0.3.0"},
+
+		// A prerelease that already covers the bump is only graduated.
+		{BumpMajor, "2.0.0-rc.1", "2.0.0"},
+		{BumpMinor, "1.3.0-rc.1", "1.3.0"},
+		{BumpMinor, "2.0.0-rc.1", "2.0.0"},
+		{BumpPatch, "1.2.3-rc.1", "1.2.3"},
+
+		// Otherwise it is graduated, then bumped.
+		{BumpMajor, "1.2.3-rc.1", "2.0.0"},
+		{BumpMajor, "1.2.0-rc.1", "2.0.0"},
+		{BumpMinor, "1.2.3-rc.1", "1.3.0"},
+	}
+
+	for _, tt := range tests {
+		for _, reports := range [][]VersionReport{
+			{{Key: "graduate", BumpType: BumpGraduate}, {Key: "bump", BumpType: tt.bump}},
+			{{Key: "bump", BumpType: tt.bump}, {Key: "graduate", BumpType: BumpGraduate}},
+		} {
+			t.Run(string(tt.bump)+" "+tt.previous, func(t *testing.T) {
+				merged := &MergedVersionReport{Reports: reports}
+				assert.Equal(t, BumpResolution{BumpType: tt.bump, Key: "bump", GraduateKey: "graduate"}, merged.EffectiveBumpType())
+
+				next, err := merged.NextVersion(tt.previous)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, next)
+			})
+		}
+	}
+
+	// Graduation alone.
+	merged := &MergedVersionReport{Reports: []VersionReport{{Key: "graduate", BumpType: BumpGraduate}, {Key: "pre", BumpType: BumpPrerelease}}}
+	next, err := merged.NextVersion("1.2.3-rc.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.2.3", next)
+}
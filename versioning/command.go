@@ -0,0 +1,85 @@
+package versioning
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// COMMAND_ENV_VAR holds the JSON encoded command line of a subprocess started
+// with Command or DecorateCommand. Every record the subprocess writes is
+// tagged with it.
+const COMMAND_ENV_VAR = "SPEAKEASY_VERSION_REPORT_COMMAND"
+
+// Command is like exec.CommandContext, with the environment of the returned
+// command pointed at the report destination of ctx (see DecorateCommand).
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return DecorateCommand(ctx, exec.CommandContext(ctx, name, args...))
+}
+
+// DecorateCommand points the environment of cmd at the recorder attached to
+// ctx, whether that is a file, a directory or a socket collector, so that the
+// reports written by the subprocess end up in the same place as those written
+// with ctx. Destination variables already in cmd.Env, or in the process
+// environment if cmd.Env is nil, are replaced. The reports are also tagged
+// with cmd.Args, which can be read back with VersionReport.Command and
+// VersionReportV2Target.Command.
+//
+// Recorders that subprocesses cannot reach, such as in-memory ones, leave the
+// subprocess without a destination, so its reports are dropped.
+func DecorateCommand(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	decorated := make([]string, 0, len(env)+len(environKeys)+1)
+	for _, entry := range env {
+		key, _, _ := strings.Cut(entry, "=")
+		if !isDestinationKey(key) {
+			decorated = append(decorated, entry)
+		}
+	}
+	decorated = append(decorated, RecorderFromContext(ctx).Environ()...)
+	if command, err := json.Marshal(cmd.Args); err == nil {
+		decorated = append(decorated, COMMAND_ENV_VAR+"="+string(command))
+	}
+
+	cmd.Env = decorated
+	return cmd
+}
+
+func isDestinationKey(key string) bool {
+	if key == COMMAND_ENV_VAR {
+		return true
+	}
+	for _, environKey := range environKeys {
+		if key == environKey {
+			return true
+		}
+	}
+	return false
+}
+
+// commandFromEnv returns the command line in COMMAND_ENV_VAR, if any.
+func commandFromEnv() []string {
+	var command []string
+	if value := os.Getenv(COMMAND_ENV_VAR); len(value) > 0 {
+		json.Unmarshal([]byte(value), &command)
+	}
+	return command
+}
+
+// Command returns the command line of the subprocess that wrote r, if it was
+// started with Command or DecorateCommand.
+func (r VersionReport) Command() []string {
+	return r.command
+}
+
+// Command returns the command line of the subprocess that wrote t, if it was
+// started with Command or DecorateCommand.
+func (t VersionReportV2Target) Command() []string {
+	return t.command
+}
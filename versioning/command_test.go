@@ -0,0 +1,150 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildSubprocess(t *testing.T) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "subprocess")
+	out, err := exec.Command("go", "build", "-o", binary, "testdata/subprocess.go").CombinedOutput()
+	require.NoError(t, err, string(out))
+	return binary
+}
+
+func TestCommand(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs subprocesses")
+	}
+	binary := buildSubprocess(t)
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	tests := []struct {
+		name string
+		opts []CaptureOption
+	}{
+		{name: "file"},
+		{name: "socket", opts: []CaptureOption{WithSocketCollector()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cmd *exec.Cmd
+			outer, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+				capture, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (bool, error) {
+					cmd = Command(ctx, binary, "1", "From a subprocess")
+					return true, cmd.Run()
+				}, tt.opts...)
+				require.NoError(t, err)
+				require.Len(t, capture.V1.Reports, 1)
+				assert.Equal(t, "From a subprocess", capture.V1.Reports[0].PRReport)
+				assert.Equal(t, cmd.Args, capture.V1.Reports[0].Command())
+				return true, nil
+			})
+			require.NoError(t, err)
+
+			// The tag survives being forwarded to the enclosing capture.
+			require.Len(t, outer.V1.Reports, 1)
+			assert.Equal(t, []string{binary, "1", "From a subprocess"}, outer.V1.Reports[0].Command())
+		})
+	}
+}
+
+func TestDecorateCommandWithRecorder(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs subprocesses")
+	}
+	binary := buildSubprocess(t)
+
+	location := filepath.Join(t.TempDir(), "version.json")
+	recorder := NewFileRecorder(location)
+	ctx := WithRecorder(context.Background(), recorder)
+
+	cmd := exec.Command(binary, "2", "Decorated")
+	// A stale destination is replaced by the recorder's.
+	cmd.Env = append(os.Environ(), ENV_VAR_PREFIX+"="+filepath.Join(t.TempDir(), "stale.json"))
+	require.NoError(t, DecorateCommand(ctx, cmd).Run())
+
+	capture, err := recorder.Read(ctx)
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.Equal(t, "subprocess2", capture.V1.Reports[0].Key)
+	assert.Equal(t, []string{binary, "2", "Decorated"}, capture.V1.Reports[0].Command())
+
+	provenance := capture.V1.Provenance("subprocess2")
+	require.NotNil(t, provenance)
+	assert.Equal(t, cmd.Process.Pid, provenance.Pid)
+	assert.Equal(t, "subprocess", provenance.Executable)
+}
+
+func TestDecorateCommandEnviron(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	ctx := WithDestination(context.Background(), location)
+
+	cmd := DecorateCommand(ctx, &exec.Cmd{
+		Args: []string{"generate", "--lang", "go"},
+		Env:  []string{"PATH=/bin", SOCKET_ENV_VAR + "=/stale.sock", COMMAND_ENV_VAR + "=[\"parent\"]"},
+	})
+	assert.Equal(t, []string{
+		"PATH=/bin",
+		ENV_VAR_PREFIX + "=" + location,
+		V2_LOCATION_ENV_VAR + "=" + v2LocationFor(location),
+		COMMAND_ENV_VAR + `=["generate","--lang","go"]`,
+	}, cmd.Env)
+}
+
+func TestCommandTagRoundTrip(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	ctx := WithRecorder(context.Background(), NewRecorder(sink))
+
+	t.Setenv(COMMAND_ENV_VAR, `["generate","go"]`)
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "go"}))
+	require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+
+	reports, err := sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"generate", "go"}, reports[0].Command())
+	targets, err := sink.ReadVersionReportV2Targets(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"generate", "go"}, targets[0].Command())
+
+	// The payload is unchanged for existing consumers.
+	data, err := os.ReadFile(location)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"command":["generate","go"],`)
+	assert.Contains(t, string(data), `,"key":"go"`)
+}
+
+func TestCommandWithConfiguredFileSink(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs subprocesses")
+	}
+	binary := buildSubprocess(t)
+	os.Unsetenv(ENV_VAR_PREFIX)
+	sink := NewFileSink(filepath.Join(t.TempDir(), "version.json"))
+	SetReportSink(sink)
+	defer SetReportSink(nil)
+
+	// Outside a capture, subprocesses report into the configured file.
+	assert.Equal(t, destinationOf(sink), RecorderFromContext(context.Background()).Environ())
+
+	capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "parent"}))
+		return true, Command(ctx, binary, "1", "From a subprocess").Run()
+	})
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 2)
+	assert.Equal(t, "subprocess1", capture.V1.Reports[0].Key)
+
+	// The reports are forwarded to the configured sink.
+	reports, err := sink.ReadVersionReports(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, reports, 2)
+}
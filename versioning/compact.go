@@ -0,0 +1,280 @@
+package versioning
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// COMPACT_THRESHOLD_ENV_VAR is the size in bytes at which writers compact a
+// report file automatically (see FileOptions.CompactThreshold).
+const COMPACT_THRESHOLD_ENV_VAR = "SPEAKEASY_VERSION_REPORT_COMPACT_THRESHOLD"
+
+// V2_COMPACTION_ENV_VAR selects the V2CompactionPolicy used by writers.
+const V2_COMPACTION_ENV_VAR = "SPEAKEASY_VERSION_REPORT_V2_COMPACTION"
+
+// V2CompactionPolicy decides which V2 targets a compaction keeps.
+type V2CompactionPolicy string
+
+const (
+	// V2KeepAll leaves V2 files untouched, since readers return every target
+	// that was written. It is the default.
+	V2KeepAll V2CompactionPolicy = "keep_all"
+	// V2LatestPerTarget keeps only the last target written for each TargetName.
+	V2LatestPerTarget V2CompactionPolicy = "latest_per_target"
+)
+
+// Compactor is implemented by sinks whose storage grows with superseded
+// records, such as FileSink and DirSink.
+type Compactor interface {
+	// Compact drops superseded records without changing the merged view, as
+	// merged with the strategies the sink knows of: those registered with
+	// SetMergeStrategy and those in its FileOptions.MergeStrategies.
+	Compact(ctx context.Context) error
+}
+
+// Compact compacts the report files of the recorder attached to ctx, or the
+// files at ENV_VAR_PREFIX. Sinks that do not implement Compactor are left as
+// they are.
+func Compact(ctx context.Context) error {
+	sink := RecorderFromContext(ctx).reportSink()
+	if sink == nil {
+		return ErrLocationNotSet
+	}
+	if compactor, ok := sink.(Compactor); ok {
+		return compactor.Compact(ctx)
+	}
+	return nil
+}
+
+// Compact rewrites the V1 file so that only the winning record for each Key
+// remains, and the V2 file according to V2Compaction. Keys merged with a
+// strategy other than MergeLastWins, which may need earlier records, keep all
+// of them, and keys whose records disagree keep enough of them for Conflicts
+// to be unchanged. Each file is replaced
+// atomically while holding its lock, so concurrent writers and readers in
+// other processes see either the old file or the compacted one.
+func (s *FileSink) Compact(ctx context.Context) error {
+	var errs []error
+	if len(s.Location) > 0 {
+		fileMutex.Lock()
+		errs = append(errs, compactFile(s.Location, ReportKindV1, v1CompactionKey, (*conflictTracker).addVersionReport, s.keepsEveryRecord()))
+		fileMutex.Unlock()
+	}
+	if len(s.V2Location) > 0 && s.V2Compaction == V2LatestPerTarget {
+		v2FileMutex.Lock()
+		errs = append(errs, compactFile(s.V2Location, ReportKindV2, v2CompactionKey, nil, nil))
+		v2FileMutex.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// Compact compacts every shard in the directory. Since a record supersedes
+// only older records, and the latest record for a key is also the latest in
+// its own shard, compacting shards one by one preserves the merged view. Keys
+// merged with other strategies keep every record in every shard.
+func (s *DirSink) Compact(ctx context.Context) error {
+	var errs []error
+	for _, suffix := range []string{v1ShardSuffix, v2ShardSuffix} {
+		shards, err := s.shards(suffix)
+		if err != nil {
+			return err
+		}
+		for _, shard := range shards {
+			sink := &FileSink{FileOptions: s.FileOptions}
+			if suffix == v1ShardSuffix {
+				sink.Location = shard
+			} else {
+				sink.V2Location = shard
+			}
+			errs = append(errs, sink.Compact(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Compact compacts every sink that implements Compactor.
+func (s *MultiSink) Compact(ctx context.Context) error {
+	var errs []error
+	for _, sink := range s.sinks {
+		if compactor, ok := sink.(Compactor); ok {
+			errs = append(errs, compactor.Compact(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keepsEveryRecord returns whether compaction must keep every V1 record for a
+// key, because its merge strategy is not MergeLastWins, or because the file
+// keeps the history of a capture.
+func (o FileOptions) keepsEveryRecord() func(key string) bool {
+	if o.keepHistory {
+		return func(key string) bool { return true }
+	}
+	merger := newVersionReportMerger(o.MergeStrategies)
+	return func(key string) bool {
+		return merger.strategyFor(key) != MergeLastWins
+	}
+}
+
+func v1CompactionKey(report VersionReport) string {
+	return report.Key
+}
+
+func v2CompactionKey(target VersionReportV2Target) string {
+	return target.TargetName
+}
+
+// compactedSizes remembers the size of each file after its last automatic
+// compaction in this process, so that a file which cannot shrink below the
+// threshold is not rewritten on every append.
+var compactedSizes sync.Map
+
+// compactIfNeeded compacts the file at location after an append made it reach
+// the threshold. The caller holds the file's exclusive lock.
+//
+// The record has already been appended by then, so a compaction that fails,
+// for example because the file holds a line left truncated by a crashed
+// writer, does not fail the write: the file is left as it is, and compaction
+// is only attempted again once the file has doubled in size.
+func (s *FileSink) compactIfNeeded(f *os.File, location string, kind ReportKind) {
+	if s.CompactThreshold <= 0 || (kind == ReportKindV2 && s.V2Compaction != V2LatestPerTarget) {
+		return
+	}
+	info, err := f.Stat()
+	if err != nil || info.Size() < s.CompactThreshold {
+		return
+	}
+	if previous, ok := compactedSizes.Load(location); ok && info.Size() < 2*previous.(int64) {
+		return
+	}
+
+	var size int64
+	if kind == ReportKindV1 {
+		size, err = compactLocked(location, kind, v1CompactionKey, (*conflictTracker).addVersionReport, s.keepsEveryRecord())
+	} else {
+		size, err = compactLocked(location, kind, v2CompactionKey, nil, nil)
+	}
+	if err != nil {
+		size = info.Size()
+	}
+	compactedSizes.Store(location, size)
+}
+
+// compactFile compacts the file at location under its exclusive lock. A
+// missing file is left alone.
+func compactFile[T any](location string, kind ReportKind, keyOf func(T) string, track func(*conflictTracker, T) bool, keepAll func(key string) bool) error {
+	f, err := openLocked(location, os.O_RDONLY, 0, true)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	defer unlockFile(f)
+
+	_, err = compactLocked(location, kind, keyOf, track, keepAll)
+	return err
+}
+
+// compactLocked replaces the file at location with one holding only the last
+// record for each key, or every record for the keys keepAll selects, in their
+// original order. Records are copied verbatim,
+// so their envelopes and frames are kept. If track is set, the first record
+// with each value it tracks is kept as well for keys whose records disagree,
+// so that the conflict is still reported once the file is compacted. The file is left
+// unchanged if any record fails to decode. The caller holds the file's
+// exclusive lock; the new size of the file is returned.
+func compactLocked[T any](location string, kind ReportKind, keyOf func(T) string, track func(*conflictTracker, T) bool, keepAll func(key string) bool) (int64, error) {
+	type survivor struct {
+		index int
+		line  []byte
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	cursor := newRecordCursor[T](location, kind, &snapshot{Reader: f, f: f})
+	latest := make(map[string]survivor)
+	var kept []survivor
+	var tracker conflictTracker
+	// firsts holds, by key, the records that were the first with a value.
+	firsts := make(map[string][]survivor)
+	for index := 0; ; index++ {
+		record, _, err := cursor.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		key := keyOf(record)
+		s := survivor{index: index, line: bytes.Clone(cursor.line)}
+		if keepAll != nil && keepAll(key) {
+			kept = append(kept, s)
+			continue
+		}
+		if track != nil && track(&tracker, record) {
+			firsts[key] = append(firsts[key], s)
+		}
+		latest[key] = s
+	}
+
+	survivors := make([]survivor, 0, len(latest)+len(kept))
+	survivors = append(survivors, kept...)
+	for key, s := range latest {
+		survivors = append(survivors, s)
+		if tracker.disagrees(key) {
+			for _, first := range firsts[key] {
+				if first.index != s.index {
+					survivors = append(survivors, first)
+				}
+			}
+		}
+	}
+	sort.Slice(survivors, func(i, j int) bool {
+		return survivors[i].index < survivors[j].index
+	})
+
+	tempFile, err := os.CreateTemp(filepath.Dir(location), filepath.Base(location)+".compact-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	var size int64
+	for _, s := range survivors {
+		n, err := tempFile.Write(append(s.line, '\n'))
+		if err != nil {
+			return 0, err
+		}
+		size += int64(n)
+	}
+	if err := tempFile.Chmod(info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	if err := tempFile.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tempFile.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tempFile.Name(), location); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
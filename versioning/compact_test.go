@@ -0,0 +1,326 @@
+package versioning
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countLines(t *testing.T, location string) int {
+	t.Helper()
+	data, err := os.ReadFile(location)
+	require.NoError(t, err)
+	return bytes.Count(data, []byte{'\n'})
+}
+
+func TestCompact(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	ctx := WithRecorder(context.Background(), NewRecorder(sink))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "a", Priority: 1, PRReport: fmt.Sprintf("a%d", i)}))
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "b", Priority: 1, PRReport: fmt.Sprintf("b%d", i)}))
+		require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: fmt.Sprintf("1.0.%d", i)}))
+	}
+	before, err := ReadVersionReports(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, Compact(ctx))
+	assert.Equal(t, 2, countLines(t, location))
+	assert.Equal(t, 3, countLines(t, sink.V2Location), "V2 targets are kept by default")
+
+	after, err := ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, after.V1.Reports, 2)
+	assert.Equal(t, before.V1.GetMarkdownSection(), after.V1.GetMarkdownSection())
+	assert.Equal(t, "b2", after.V1.Reports[0].PRReport)
+	assert.Equal(t, "a2", after.V1.Reports[1].PRReport)
+
+	sink.V2Compaction = V2LatestPerTarget
+	require.NoError(t, Compact(ctx))
+	assert.Equal(t, 1, countLines(t, sink.V2Location))
+	targets, err := sink.ReadVersionReportV2Targets(ctx)
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "1.0.2", targets[0].NewVersion)
+
+	// Writers keep appending to the compacted file.
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "c", Priority: 1}))
+	assert.Equal(t, 3, countLines(t, location))
+}
+
+func TestCompactKeepsRecordsVerbatim(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := &FileSink{Location: location, FileOptions: FileOptions{Framed: true}}
+	ctx := context.Background()
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "a", PRReport: "old"}))
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "a", PRReport: "new"}))
+	data, err := os.ReadFile(location)
+	require.NoError(t, err)
+	last := data[bytes.IndexByte(data, '\n')+1:]
+
+	require.NoError(t, sink.Compact(ctx))
+	compacted, err := os.ReadFile(location)
+	require.NoError(t, err)
+	assert.Equal(t, string(last), string(compacted))
+}
+
+func TestCompactLeavesCorruptFileUnchanged(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	data := `{"key":"a","pr_report":"old"}
+{"key":"a","pr_re
+{"key":"a","pr_report":"new"}
+`
+	require.NoError(t, os.WriteFile(location, []byte(data), 0644))
+
+	err := NewFileSink(location).Compact(context.Background())
+	assert.ErrorIs(t, err, ErrCorruptReport)
+	contents, err := os.ReadFile(location)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(contents))
+}
+
+func TestCompactMissingFile(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	require.NoError(t, NewFileSink(location).Compact(context.Background()))
+	_, err := os.Stat(location)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.ErrorIs(t, Compact(WithRecorder(context.Background(), &Recorder{})), ErrLocationNotSet)
+}
+
+func TestAutomaticCompaction(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	sink.CompactThreshold = 1024
+	sink.V2Compaction = V2LatestPerTarget
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "a", PRReport: fmt.Sprintf("report %d", i)}))
+		require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: fmt.Sprintf("1.0.%d", i)}))
+	}
+
+	info, err := os.Stat(location)
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), int64(2048))
+	info, err = os.Stat(sink.V2Location)
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), int64(2048))
+
+	reports, err := sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "report 99", reports[len(reports)-1].PRReport)
+}
+
+func TestAutomaticCompactionOfCorruptFile(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	require.NoError(t, os.WriteFile(location, []byte(`{"key":"broken","pri`+"\n"), 0644))
+	sink := NewFileSink(location)
+	sink.CompactThreshold = 256
+	ctx := context.Background()
+
+	// Compaction fails on the corrupt line, but every record is still
+	// appended successfully, and exactly once.
+	for i := 0; i < 20; i++ {
+		require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "a", PRReport: fmt.Sprintf("report %d", i)}))
+	}
+
+	reports, diagnostics, err := collect(sink.StreamVersionReports(ctx), true)
+	require.NoError(t, err)
+	assert.Len(t, diagnostics, 1)
+	require.Len(t, reports, 20)
+	assert.Equal(t, "report 19", reports[19].PRReport)
+}
+
+func TestDirSinkCompact(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewDirSink(dir)
+	sink.V2Compaction = V2LatestPerTarget
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "a", PRReport: fmt.Sprintf("a%d", i)}))
+		require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: fmt.Sprintf("1.0.%d", i)}))
+	}
+	require.NoError(t, sink.Compact(ctx))
+
+	shard, err := sink.shard()
+	require.NoError(t, err)
+	assert.Equal(t, 1, countLines(t, shard.Location))
+	assert.Equal(t, 1, countLines(t, shard.V2Location))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+}
+
+func TestCompactionWithSubprocessWriters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns many writer processes")
+	}
+
+	const (
+		writers = 16
+		records = 20
+		size    = 4 * 1024
+	)
+
+	binary := filepath.Join(t.TempDir(), "stresswriter")
+	build := exec.Command("go", "build", "-o", binary, "testdata/stresswriter.go")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	sink.CompactThreshold = 64 * 1024
+	sink.V2Compaction = V2LatestPerTarget
+	recorder := NewRecorder(sink)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			cmd := exec.Command(binary, fmt.Sprint(w), fmt.Sprint(records), fmt.Sprint(size))
+			cmd.Env = append(os.Environ(), recorder.Environ()...)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("writer %d: %w: %s", w, err, out)
+			}
+		}(w)
+	}
+	// Compact concurrently with the writers as well.
+	for i := 0; i < 10; i++ {
+		require.NoError(t, sink.Compact(context.Background()))
+	}
+	wg.Wait()
+	close(errs)
+	require.NoError(t, <-errs)
+
+	capture, err := recorder.Read(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, capture.V1.Reports, writers*records)
+	assert.Len(t, capture.V2.Targets, writers*records)
+	for _, report := range capture.V1.Reports {
+		assert.Equal(t, strings.Repeat(report.Key, size/len(report.Key)+1)[:size], report.PRReport)
+	}
+}
+
+func TestAutomaticCompactionKeepsConflicts(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	t.Setenv(COMPACT_THRESHOLD_ENV_VAR, "1")
+
+	capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		for _, version := range []string{"1.0.0", "2.0.0", "2.0.0", "3.0.0"} {
+			require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "sdk", NewVersion: version}))
+		}
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "docs", NewVersion: "1.0.0"}))
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "docs", NewVersion: "1.0.0"}))
+		// The repeated versions are compacted, but not the conflicting ones.
+		require.NoError(t, Compact(ctx))
+		assert.Equal(t, 4, countLines(t, os.Getenv(ENV_VAR_PREFIX)))
+		return true, nil
+	}, WithStrictConflicts())
+	assert.ErrorIs(t, err, ErrVersionConflict)
+	require.NotNil(t, capture)
+	assert.Equal(t, []VersionConflict{
+		{Key: "sdk", Field: ConflictNewVersion, Values: []string{"1.0.0", "2.0.0", "3.0.0"}},
+	}, capture.Conflicts())
+	require.Len(t, capture.V1.Reports, 2)
+	assert.Equal(t, "3.0.0", capture.V1.Reports[1].NewVersion)
+}
+
+func TestCompactPreservesMergeStrategies(t *testing.T) {
+	reports := []VersionReport{
+		{Key: "sdk", Priority: 2, BumpType: BumpMinor, PRReport: "First"},
+		{Key: "docs", BumpType: BumpNone, PRReport: "Docs 1"},
+		{Key: "sdk", Priority: 3, BumpType: BumpPatch, PRReport: "Second", MustGenerate: true},
+		{Key: "docs", BumpType: BumpNone, PRReport: "Docs 2"},
+		{Key: "sdk", Priority: 1, BumpType: BumpMajor, PRReport: "Third"},
+	}
+	// normalize drops what compaction is allowed to change: the read order.
+	normalize := func(merged *MergedVersionReport) []VersionReport {
+		normalized := make([]VersionReport, len(merged.Reports))
+		for i, report := range merged.Reports {
+			report.readIndex = 0
+			report.provenance = nil
+			normalized[i] = report
+		}
+		return normalized
+	}
+
+	strategies := []MergeStrategy{MergeLastWins, MergeFirstWins, MergeHighestPriority, MergeConcatenate, MergeErrorOnConflict}
+	configure := map[string]func(t *testing.T, sink *FileSink, strategy MergeStrategy){
+		"registered": func(t *testing.T, sink *FileSink, strategy MergeStrategy) {
+			SetMergeStrategy("sdk", strategy)
+			t.Cleanup(func() { SetMergeStrategy("sdk", "") })
+		},
+		"file options": func(t *testing.T, sink *FileSink, strategy MergeStrategy) {
+			sink.MergeStrategies = map[string]MergeStrategy{"sdk": strategy}
+		},
+	}
+	for name, configure := range configure {
+		for _, strategy := range strategies {
+			t.Run(name+"/"+string(strategy), func(t *testing.T) {
+				location := filepath.Join(t.TempDir(), "version.json")
+				sink := NewFileSink(location)
+				configure(t, sink, strategy)
+				recorder := NewRecorder(sink)
+				ctx := context.Background()
+				for _, report := range reports {
+					require.NoError(t, recorder.AddVersionReport(ctx, report))
+				}
+
+				before, beforeErr := recorder.Read(ctx)
+				require.NoError(t, sink.Compact(ctx))
+				after, afterErr := recorder.Read(ctx)
+
+				assert.Equal(t, beforeErr != nil, afterErr != nil)
+				assert.Equal(t, normalize(before.V1), normalize(after.V1))
+				assert.Equal(t, before.Conflicts(), after.Conflicts())
+				// Only the docs key is compacted: the sdk reports disagree, so
+				// they are kept whatever their strategy.
+				assert.Equal(t, 4, countLines(t, location))
+			})
+		}
+	}
+
+	// A capture's own strategies are honoured by the automatic compaction of
+	// its files.
+	for _, strategy := range strategies {
+		t.Run("capture/"+string(strategy), func(t *testing.T) {
+			sink := NewFileSink(filepath.Join(t.TempDir(), "version.json"))
+			sink.CompactThreshold = 1024
+			recorder := NewRecorder(sink)
+
+			capture, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+				for _, report := range reports {
+					require.NoError(t, AddVersionReport(ctx, report))
+				}
+				for i := 0; i < 20; i++ {
+					require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "docs", PRReport: fmt.Sprintf("Docs %d", i+3)}))
+				}
+				scope := RecorderFromContext(ctx).reportSink().(*FileSink)
+				assert.Less(t, countLines(t, scope.Location), len(reports)+20, "the capture's file is compacted")
+				return nil
+			}, WithMergeStrategy("sdk", strategy))
+
+			expected, expectedErr := mergeVersionReports(append(reports, VersionReport{Key: "docs", BumpType: BumpNone, PRReport: "Docs 22"}), mergeStrategies{"sdk": strategy})
+			assert.Equal(t, expectedErr != nil, err != nil)
+			require.NotNil(t, capture)
+			assert.Equal(t, normalize(expected), normalize(capture.V1))
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package versioning
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConflictField names the part of a report that reports disagree on.
+type ConflictField string
+
+const (
+	ConflictNewVersion   ConflictField = "new_version"
+	ConflictBumpType     ConflictField = "bump_type"
+	ConflictMustGenerate ConflictField = "must_generate"
+)
+
+// VersionConflict describes reports for the same V1 key, or V2 target, that
+// disagree on a field. Empty versions are not taken into account.
+type VersionConflict struct {
+	Key    string // set for V1 reports
+	Target string // set for V2 targets
+	Field  ConflictField
+	// Values lists the distinct values, in the order they were first written.
+	Values []string
+}
+
+func (c VersionConflict) String() string {
+	subject := "key " + strconv.Quote(c.Key)
+	if len(c.Target) > 0 {
+		subject = "target " + strconv.Quote(c.Target)
+	}
+	return fmt.Sprintf("%s: %s is %s", subject, c.Field, strings.Join(c.Values, " or "))
+}
+
+// VersionConflictError is returned by captures made with WithStrictConflicts
+// when reports disagree. It matches ErrVersionConflict with errors.Is.
+type VersionConflictError struct {
+	Conflicts []VersionConflict
+}
+
+func (e *VersionConflictError) Error() string {
+	descriptions := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		descriptions[i] = conflict.String()
+	}
+	return "version reports disagree: " + strings.Join(descriptions, "; ")
+}
+
+func (e *VersionConflictError) Is(target error) bool {
+	return target == ErrVersionConflict
+}
+
+// WithStrictConflicts makes the capture fail with a *VersionConflictError if
+// any of its reports disagree, as listed by VersionReportCapture.Conflicts.
+// The capture is still returned.
+func WithStrictConflicts() CaptureOption {
+	return func(o *captureOptions) {
+		o.strict = true
+	}
+}
+
+// Conflicts lists the V1 keys whose reports disagree on NewVersion, BumpType
+// or MustGenerate, including reports that were superseded while merging.
+func (m *MergedVersionReport) Conflicts() []VersionConflict {
+	return m.conflicts
+}
+
+// Conflicts lists the targets reported more than once with different
+// NewVersion values.
+func (d *VersionReportV2Data) Conflicts() []VersionConflict {
+	var tracker conflictTracker
+	for _, target := range d.Targets {
+		tracker.add(target.TargetName, ConflictNewVersion, target.NewVersion)
+	}
+	return tracker.conflicts(func(c *VersionConflict, name string) { c.Target = name })
+}
+
+// Conflicts lists the conflicts in both the V1 and the V2 reports.
+func (c *VersionReportCapture) Conflicts() []VersionConflict {
+	var conflicts []VersionConflict
+	if c.V1 != nil {
+		conflicts = append(conflicts, c.V1.Conflicts()...)
+	}
+	if c.V2 != nil {
+		conflicts = append(conflicts, c.V2.Conflicts()...)
+	}
+	return conflicts
+}
+
+// conflictFields is the order in which conflicts are listed for a key.
+var conflictFields = []ConflictField{ConflictNewVersion, ConflictBumpType, ConflictMustGenerate}
+
+// conflictTracker collects the distinct values of each field for every key or
+// target.
+type conflictTracker struct {
+	names  []string
+	values map[string]map[ConflictField][]string
+}
+
+// add records value for the field of name, and reports whether it had not
+// been seen yet.
+func (t *conflictTracker) add(name string, field ConflictField, value string) bool {
+	if field == ConflictNewVersion && len(value) == 0 {
+		return false
+	}
+	if t.values == nil {
+		t.values = make(map[string]map[ConflictField][]string)
+	}
+	fields, ok := t.values[name]
+	if !ok {
+		fields = make(map[ConflictField][]string)
+		t.values[name] = fields
+		t.names = append(t.names, name)
+	}
+	for _, seen := range fields[field] {
+		if seen == value {
+			return false
+		}
+	}
+	fields[field] = append(fields[field], value)
+	return true
+}
+
+// addVersionReport records the fields of report, and reports whether any of
+// their values had not been seen yet for its key.
+func (t *conflictTracker) addVersionReport(report VersionReport) bool {
+	bump := report.BumpType
+	if len(bump) == 0 {
+		bump = BumpNone
+	}
+	added := t.add(report.Key, ConflictNewVersion, report.NewVersion)
+	added = t.add(report.Key, ConflictBumpType, string(bump)) || added
+	return t.add(report.Key, ConflictMustGenerate, strconv.FormatBool(report.MustGenerate)) || added
+}
+
+// disagrees reports whether any field has more than one value for name.
+func (t *conflictTracker) disagrees(name string) bool {
+	for _, values := range t.values[name] {
+		if len(values) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// conflicts lists the fields with more than one value, by key or target in the
+// order they were first seen. name sets the key or target of each conflict.
+func (t *conflictTracker) conflicts(name func(c *VersionConflict, name string)) []VersionConflict {
+	var conflicts []VersionConflict
+	for _, n := range t.names {
+		for _, field := range conflictFields {
+			if values := t.values[n][field]; len(values) > 1 {
+				conflict := VersionConflict{Field: field, Values: values}
+				name(&conflict, n)
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	return conflicts
+}
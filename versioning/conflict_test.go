@@ -0,0 +1,72 @@
+package versioning
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionReportConflicts(t *testing.T) {
+	merged, err := mergeVersionReports([]VersionReport{
+		{Key: "sdk", BumpType: BumpMinor, NewVersion: "1.3.0"},
+		{Key: "docs", BumpType: BumpPatch, NewVersion: "1.2.4"},
+		{Key: "sdk", BumpType: BumpMajor, NewVersion: "2.0.0", MustGenerate: true},
+		{Key: "docs", BumpType: BumpPatch, MustGenerate: false},
+		{Key: "sdk", BumpType: BumpMinor, NewVersion: "1.3.0"},
+	}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []VersionConflict{
+		{Key: "sdk", Field: ConflictNewVersion, Values: []string{"1.3.0", "2.0.0"}},
+		{Key: "sdk", Field: ConflictBumpType, Values: []string{"minor", "major"}},
+		{Key: "sdk", Field: ConflictMustGenerate, Values: []string{"false", "true"}},
+	}, merged.Conflicts())
+	assert.Equal(t, `key "sdk": new_version is 1.3.0 or 2.0.0`, merged.Conflicts()[0].String())
+}
+
+func TestVersionReportV2Conflicts(t *testing.T) {
+	data := &VersionReportV2Data{Targets: []VersionReportV2Target{
+		{TargetName: "go", NewVersion: "1.1.0"},
+		{TargetName: "typescript", NewVersion: "2.0.0"},
+		{TargetName: "go", NewVersion: "1.2.0"},
+		{TargetName: "typescript", NewVersion: "2.0.0"},
+	}}
+
+	assert.Equal(t, []VersionConflict{
+		{Target: "go", Field: ConflictNewVersion, Values: []string{"1.1.0", "1.2.0"}},
+	}, data.Conflicts())
+}
+
+func TestCaptureWithStrictConflicts(t *testing.T) {
+	recorder := NewRecorder(NewMemorySink())
+	report := func(ctx context.Context) error {
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "sdk", BumpType: BumpMinor}))
+		require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.1.0"}))
+		require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.2.0"}))
+		return AddVersionReport(ctx, VersionReport{Key: "sdk", BumpType: BumpMajor})
+	}
+
+	// Without strict mode, conflicts are only listed.
+	capture, err := recorder.Capture(context.Background(), report)
+	require.NoError(t, err)
+	assert.Len(t, capture.Conflicts(), 2)
+
+	capture, err = recorder.Capture(context.Background(), report, WithStrictConflicts())
+	require.ErrorIs(t, err, ErrVersionConflict)
+	require.NotNil(t, capture)
+
+	var conflictErr *VersionConflictError
+	require.ErrorAs(t, err, &conflictErr)
+	assert.Equal(t, []VersionConflict{
+		{Key: "sdk", Field: ConflictBumpType, Values: []string{"minor", "major"}},
+		{Target: "go", Field: ConflictNewVersion, Values: []string{"1.1.0", "1.2.0"}},
+	}, conflictErr.Conflicts)
+
+	capture, err = recorder.Capture(context.Background(), func(ctx context.Context) error {
+		return AddVersionReport(ctx, VersionReport{Key: "sdk", BumpType: BumpMinor})
+	}, WithStrictConflicts())
+	require.NoError(t, err)
+	assert.Empty(t, capture.Conflicts())
+}
@@ -0,0 +1,29 @@
+package versioning
+
+import "context"
+
+type recorderContextKey struct{}
+
+// WithRecorder returns a copy of ctx that makes AddVersionReport,
+// AddVersionReportV2Target, MustGenerate and WithVersionReportCapture use r
+// instead of the environment.
+func WithRecorder(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, recorderContextKey{}, r)
+}
+
+// WithDestination returns a copy of ctx that reports into the JSONL report
+// files at location.
+func WithDestination(ctx context.Context, location string) context.Context {
+	return WithRecorder(ctx, NewFileRecorder(location))
+}
+
+// RecorderFromContext returns the recorder attached to ctx, or the default
+// recorder, which reports to the location in ENV_VAR_PREFIX.
+func RecorderFromContext(ctx context.Context) *Recorder {
+	if ctx != nil {
+		if r, ok := ctx.Value(recorderContextKey{}).(*Recorder); ok && r != nil {
+			return r
+		}
+	}
+	return defaultRecorder
+}
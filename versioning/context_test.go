@@ -0,0 +1,73 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDestination(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test_env_version_report.json")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+	tempFile.Close()
+
+	os.Setenv(ENV_VAR_PREFIX, tempFile.Name())
+	defer os.Unsetenv(ENV_VAR_PREFIX)
+
+	location := filepath.Join(t.TempDir(), "version.json")
+	ctx := WithDestination(context.Background(), location)
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "test", MustGenerate: true}))
+	require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+
+	// The context destination takes precedence over the environment.
+	assert.True(t, MustGenerate(ctx))
+	assert.False(t, MustGenerate(context.Background()))
+
+	reports, err := NewFileSink(location).ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, "test", reports[0].Key)
+	assert.FileExists(t, filepath.Join(filepath.Dir(location), "version.v2.json"))
+
+	contents, err := os.ReadFile(tempFile.Name())
+	require.NoError(t, err)
+	assert.Empty(t, contents)
+}
+
+func TestRecorderFromContext(t *testing.T) {
+	assert.Same(t, defaultRecorder, RecorderFromContext(context.Background()))
+
+	recorder := NewRecorder(NewMemorySink())
+	assert.Same(t, recorder, RecorderFromContext(WithRecorder(context.Background(), recorder)))
+}
+
+func TestCaptureAttachesRecorderToContext(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	// Library code only sees the context, never the recorder.
+	deep := func(ctx context.Context) error {
+		return AddVersionReport(ctx, VersionReport{Key: "deep", MustGenerate: true})
+	}
+
+	recorder := NewRecorder(NewMemorySink())
+	ctx := WithRecorder(context.Background(), recorder)
+
+	type unknown struct{}
+	capture, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+		return nil, deep(ctx)
+	})
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.Equal(t, "deep", capture.V1.Reports[0].Key)
+
+	capture, err = NewRecorder(NewMemorySink()).Capture(context.Background(), deep)
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.Equal(t, "deep", capture.V1.Reports[0].Key)
+	assert.Empty(t, os.Getenv(ENV_VAR_PREFIX))
+}
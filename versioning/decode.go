@@ -0,0 +1,108 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maxSnippetLength bounds the raw text kept in a ReadDiagnostic.
+const maxSnippetLength = 256
+
+// ReadDiagnostic describes a stored record that could not be decoded and was
+// skipped by a lenient read.
+type ReadDiagnostic struct {
+	Location string // file the record was read from
+	Line     int    // 1-based line number
+	Offset   int64  // byte offset of the start of the line
+	Snippet  string // raw line, truncated to maxSnippetLength bytes
+	Err      error  // a *DecodeError
+}
+
+func (d ReadDiagnostic) String() string {
+	return fmt.Sprintf("%v (offset %d): %q", d.Err, d.Offset, d.Snippet)
+}
+
+// LenientReader is implemented by sinks whose storage can contain records that
+// fail to decode, for example lines left truncated by a crashed subprocess.
+type LenientReader interface {
+	// ReadVersionReportsLenient is like ReadVersionReports, but skips records
+	// that fail to decode and describes them in the returned diagnostics.
+	ReadVersionReportsLenient(ctx context.Context) ([]VersionReport, []ReadDiagnostic, error)
+	// ReadVersionReportV2TargetsLenient is like ReadVersionReportV2Targets, but
+	// skips records that fail to decode and describes them in the returned
+	// diagnostics.
+	ReadVersionReportV2TargetsLenient(ctx context.Context) ([]VersionReportV2Target, []ReadDiagnostic, error)
+}
+
+func newReadDiagnostic(err *DecodeError) ReadDiagnostic {
+	return ReadDiagnostic{
+		Location: err.Path,
+		Line:     err.Line,
+		Offset:   err.Offset,
+		Snippet:  err.snippet,
+		Err:      err,
+	}
+}
+
+// snippet returns raw truncated to maxSnippetLength bytes.
+func snippet(raw []byte) string {
+	if len(raw) > maxSnippetLength {
+		raw = raw[:maxSnippetLength]
+	}
+	return string(raw)
+}
+
+// readAll reads every V1 report and V2 target from sink, in the order they
+// were written. V1 reports are passed to each as they are decoded rather than
+// returned, so sinks implementing StreamReader are never held in memory whole.
+// Lenient reads fall back to strict ones for sinks that implement neither
+// StreamReader nor LenientReader. Whatever could be read is still passed on or
+// returned if reading failed, with the V1 and V2 errors joined.
+func readAll(ctx context.Context, sink ReportSink, lenient bool, each func(VersionReport)) ([]VersionReportV2Target, []ReadDiagnostic, error) {
+	diagnostics, err := readEachVersionReport(ctx, sink, lenient, each)
+
+	var targets []VersionReportV2Target
+	var diagnosticsV2 []ReadDiagnostic
+	var errV2 error
+	if lenientSink, ok := sink.(LenientReader); ok && lenient {
+		targets, diagnosticsV2, errV2 = lenientSink.ReadVersionReportV2TargetsLenient(ctx)
+	} else {
+		targets, errV2 = sink.ReadVersionReportV2Targets(ctx)
+	}
+	return targets, append(diagnostics, diagnosticsV2...), joinErrors(err, errV2)
+}
+
+func readEachVersionReport(ctx context.Context, sink ReportSink, lenient bool, each func(VersionReport)) ([]ReadDiagnostic, error) {
+	if streamSink, ok := sink.(StreamReader); ok {
+		var diagnostics []ReadDiagnostic
+		var err error
+		streamSink.StreamVersionReports(ctx)(func(report VersionReport, e error) bool {
+			var decodeErr *DecodeError
+			switch {
+			case e == nil:
+				each(report)
+			case lenient && errors.As(e, &decodeErr):
+				diagnostics = append(diagnostics, newReadDiagnostic(decodeErr))
+			default:
+				err = e
+				return false
+			}
+			return true
+		})
+		return diagnostics, err
+	}
+
+	var reports []VersionReport
+	var diagnostics []ReadDiagnostic
+	var err error
+	if lenientSink, ok := sink.(LenientReader); ok && lenient {
+		reports, diagnostics, err = lenientSink.ReadVersionReportsLenient(ctx)
+	} else {
+		reports, err = sink.ReadVersionReports(ctx)
+	}
+	for _, report := range reports {
+		each(report)
+	}
+	return diagnostics, err
+}
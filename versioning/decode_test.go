@@ -0,0 +1,150 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLenientRead(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	v1 := `{"key":"test1","priority":1,"pr_report":"Test report 1"}
+{"key":"broken","pri
+{"key":"test2","priority":1,"pr_report":"Test report 2"}
+
+not json at all
+{"key":"test3","pr_re`
+	require.NoError(t, os.WriteFile(location, []byte(v1), 0644))
+	v2 := `{"target_name":"typescript","new_version":"1.0.0"}
+{"target_name":"go","new_vers`
+	require.NoError(t, os.WriteFile(v2LocationFor(location), []byte(v2), 0644))
+
+	ctx := WithDestination(context.Background(), location)
+
+	_, err := ReadVersionReports(ctx)
+	assert.Error(t, err)
+
+	capture, err := ReadVersionReports(ctx, WithLenientDecoding())
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 2)
+	assert.Equal(t, "test2", capture.V1.Reports[0].Key)
+	assert.Equal(t, "test1", capture.V1.Reports[1].Key)
+	require.NotNil(t, capture.V2)
+	require.Len(t, capture.V2.Targets, 1)
+	assert.Equal(t, "typescript", capture.V2.Targets[0].TargetName)
+
+	require.Len(t, capture.Diagnostics, 4)
+	assert.Equal(t, location, capture.Diagnostics[0].Location)
+	assert.Equal(t, 2, capture.Diagnostics[0].Line)
+	assert.Equal(t, int64(57), capture.Diagnostics[0].Offset)
+	assert.Equal(t, `{"key":"broken","pri`, capture.Diagnostics[0].Snippet)
+	assert.Error(t, capture.Diagnostics[0].Err)
+	assert.Equal(t, 5, capture.Diagnostics[1].Line)
+	assert.Equal(t, 6, capture.Diagnostics[2].Line)
+	assert.Equal(t, v2LocationFor(location), capture.Diagnostics[3].Location)
+	assert.Equal(t, 2, capture.Diagnostics[3].Line)
+}
+
+func TestLenientReadSkipsInvalidSchemaVersion(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	v1 := `{"_envelope":{"kind":"v1"},"key":"x"}
+{"key":"valid","pr_report":"Valid"}
+`
+	require.NoError(t, os.WriteFile(location, []byte(v1), 0644))
+	ctx := WithDestination(context.Background(), location)
+
+	_, err := ReadVersionReports(ctx)
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, 1, decodeErr.Line)
+
+	capture, err := ReadVersionReports(ctx, WithLenientDecoding())
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.Equal(t, "valid", capture.V1.Reports[0].Key)
+	require.Len(t, capture.Diagnostics, 1)
+	assert.Equal(t, 1, capture.Diagnostics[0].Line)
+}
+
+func TestLenientReadTruncatesSnippets(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	long := make([]byte, 2*maxSnippetLength)
+	for i := range long {
+		long[i] = 'x'
+	}
+	require.NoError(t, os.WriteFile(location, long, 0644))
+
+	_, diagnostics, err := NewFileSink(location).ReadVersionReportsLenient(context.Background())
+	require.NoError(t, err)
+	require.Len(t, diagnostics, 1)
+	assert.Len(t, diagnostics[0].Snippet, maxSnippetLength)
+}
+
+func TestWithVersionReportCaptureLenient(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	ctx := context.Background()
+	type unknown struct{}
+
+	crash := func(ctx context.Context) (*unknown, error) {
+		if err := AddVersionReport(ctx, VersionReport{Key: "test", MustGenerate: true}); err != nil {
+			return nil, err
+		}
+		// Simulate a subprocess that crashed halfway through a write.
+		f, err := os.OpenFile(os.Getenv(ENV_VAR_PREFIX), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		_, err = f.WriteString(`{"key":"crashed","must_gen`)
+		return nil, err
+	}
+
+	_, _, err := WithVersionReportCapture(ctx, crash)
+	assert.Error(t, err)
+
+	capture, _, err := WithVersionReportCapture(ctx, crash, WithLenientDecoding())
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.True(t, capture.V1.MustGenerate())
+	require.Len(t, capture.Diagnostics, 1)
+	assert.Equal(t, 2, capture.Diagnostics[0].Line)
+}
+
+func TestWriteAfterTornLine(t *testing.T) {
+	for name, framed := range map[string]bool{"plain": false, "framed": true} {
+		t.Run(name, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "version.json")
+			sink := NewFileSink(location)
+			sink.Framed = framed
+			ctx := WithRecorder(context.Background(), NewRecorder(sink))
+
+			require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "before"}))
+			require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "before"}))
+			// A writer crashes halfway through a record in each file.
+			for _, location := range []string{sink.Location, sink.V2Location} {
+				f, err := os.OpenFile(location, os.O_APPEND|os.O_WRONLY, 0)
+				require.NoError(t, err)
+				_, err = f.WriteString(`{"key":"crashed-mid-wr`)
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+			}
+			require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "after-crash"}))
+			require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "after-crash"}))
+
+			capture, err := ReadVersionReports(ctx, WithLenientDecoding())
+			require.NoError(t, err)
+			require.Len(t, capture.V1.Reports, 2)
+			assert.Equal(t, "after-crash", capture.V1.Reports[0].Key)
+			assert.Equal(t, "before", capture.V1.Reports[1].Key)
+			require.Len(t, capture.V2.Targets, 2)
+			assert.Equal(t, "after-crash", capture.V2.Targets[1].TargetName)
+			require.Len(t, capture.Diagnostics, 2)
+			assert.Equal(t, 2, capture.Diagnostics[0].Line)
+		})
+	}
+}
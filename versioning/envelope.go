@@ -0,0 +1,270 @@
+package versioning
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CurrentSchemaVersion is the envelope version written by this package.
+// Version 1 is the original format, whose lines carry no envelope at all.
+const CurrentSchemaVersion = 2
+
+// envelopeKey holds the envelope on every stored line. The envelope sits next
+// to the payload fields rather than around them, so readers that predate it
+// still decode the payload and simply ignore the envelope.
+const envelopeKey = "_envelope"
+
+// envelope describes the format of a stored record.
+type envelope struct {
+	SchemaVersion int        `json:"schema_version"`
+	Kind          ReportKind `json:"kind"`
+	// WrittenAt orders records across the shards of a DirSink.
+	WrittenAt time.Time `json:"written_at"`
+	// Command is the command line of the subprocess that wrote the record,
+	// if it was started with Command or DecorateCommand.
+	Command []string `json:"command,omitempty"`
+	// Provenance describes the process that reported the record.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// newEnvelope returns the current envelope for record, carrying the metadata
+// the record was read or reported with.
+func newEnvelope(record any, kind ReportKind) envelope {
+	env := envelope{
+		SchemaVersion: CurrentSchemaVersion,
+		Kind:          kind,
+		WrittenAt:     time.Now().UTC(),
+	}
+	switch record := record.(type) {
+	case VersionReport:
+		env.Command, env.Provenance = record.command, record.provenance
+	case *VersionReport:
+		env.Command, env.Provenance = record.command, record.provenance
+	case VersionReportV2Target:
+		env.Command, env.Provenance = record.command, record.provenance
+	case *VersionReportV2Target:
+		env.Command, env.Provenance = record.command, record.provenance
+	}
+	return env
+}
+
+// applyEnvelope copies the metadata of env to record.
+func applyEnvelope(record any, env envelope) {
+	switch record := record.(type) {
+	case *VersionReport:
+		record.command, record.provenance = env.Command, env.Provenance
+	case *VersionReportV2Target:
+		record.command, record.provenance = env.Command, env.Provenance
+	}
+}
+
+// migration upgrades the raw fields of a record from one schema version to the
+// next.
+type migration func(fields map[string]json.RawMessage) error
+
+// migrations[kind][v] upgrades a record of the given kind from version v+1 to v+2.
+var migrations = map[ReportKind][]migration{
+	ReportKindV1: {
+		// 1 -> 2: the bump type used to be optional and defaulted to "none".
+		func(fields map[string]json.RawMessage) error {
+			if raw, ok := fields["bump_type"]; !ok || string(raw) == `""` || string(raw) == "null" {
+				fields["bump_type"] = json.RawMessage(`"` + BumpNone + `"`)
+			}
+			return nil
+		},
+	},
+	ReportKindV2: {
+		// 1 -> 2: no changes to the payload.
+		func(fields map[string]json.RawMessage) error { return nil },
+	},
+}
+
+// encodeRecord marshals record and adds the current envelope to it.
+func encodeRecord(record any, kind ReportKind) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := json.Marshal(newEnvelope(record, kind))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) + len(header) + len(envelopeKey) + 4)
+	buf.WriteString(`{"` + envelopeKey + `":`)
+	buf.Write(header)
+	if len(bytes.TrimSpace(data[1:len(data)-1])) > 0 {
+		buf.WriteByte(',')
+	}
+	buf.Write(data[1:])
+	return buf.Bytes(), nil
+}
+
+// decodeRecord decodes a stored line of the given kind into record, upgrading
+// it to CurrentSchemaVersion first if it was written by an older version, and
+// returns its envelope. Records from newer versions are decoded as is; fields
+// this version does not know about are kept in the record's Extensions.
+func decodeRecord(line []byte, kind ReportKind, record any) (envelope, error) {
+	var header struct {
+		Envelope *envelope `json:"_envelope"`
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return envelope{}, err
+	}
+
+	env := envelope{SchemaVersion: 1, Kind: kind}
+	if header.Envelope != nil {
+		env = *header.Envelope
+		if env.Kind != kind {
+			return envelope{}, fmt.Errorf("expected a %s record, found %q", kind, env.Kind)
+		}
+		if env.SchemaVersion < 1 {
+			return envelope{}, fmt.Errorf("invalid schema version %d", env.SchemaVersion)
+		}
+	}
+
+	if env.SchemaVersion < CurrentSchemaVersion {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return envelope{}, err
+		}
+		for v := env.SchemaVersion; v < CurrentSchemaVersion; v++ {
+			if err := migrations[kind][v-1](fields); err != nil {
+				return envelope{}, fmt.Errorf("failed to migrate %s record from schema version %d: %w", kind, v, err)
+			}
+		}
+		migrated, err := json.Marshal(fields)
+		if err != nil {
+			return envelope{}, err
+		}
+		line = migrated
+	}
+
+	if err := json.Unmarshal(line, record); err != nil {
+		return envelope{}, err
+	}
+	applyEnvelope(record, env)
+	return env, nil
+}
+
+// knownFields returns the JSON field names of struct type t.
+func knownFields(t reflect.Type) map[string]bool {
+	fields := map[string]bool{envelopeKey: true}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if len(name) > 0 && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+var (
+	versionReportFields         = knownFields(reflect.TypeOf(VersionReport{}))
+	versionReportV2TargetFields = knownFields(reflect.TypeOf(VersionReportV2Target{}))
+)
+
+// unknownFields returns the fields of the JSON object data that are not in
+// known, or nil if there are none.
+func unknownFields(data []byte, known map[string]bool) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name := range fields {
+		if known[name] {
+			delete(fields, name)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// appendFields adds extensions to the JSON object data, in a stable order.
+func appendFields(data []byte, extensions map[string]json.RawMessage) ([]byte, error) {
+	if len(extensions) == 0 {
+		return data, nil
+	}
+
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	body := bytes.TrimSpace(data[1 : len(data)-1])
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	buf.Write(body)
+	for i, name := range names {
+		if i > 0 || len(body) > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(extensions[name])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (r VersionReport) MarshalJSON() ([]byte, error) {
+	type plain VersionReport
+	data, err := json.Marshal(plain(r))
+	if err != nil {
+		return nil, err
+	}
+	return appendFields(data, r.Extensions)
+}
+
+func (r *VersionReport) UnmarshalJSON(data []byte) error {
+	type plain VersionReport
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	extensions, err := unknownFields(data, versionReportFields)
+	if err != nil {
+		return err
+	}
+	*r = VersionReport(p)
+	r.Extensions = extensions
+	return nil
+}
+
+func (t VersionReportV2Target) MarshalJSON() ([]byte, error) {
+	type plain VersionReportV2Target
+	data, err := json.Marshal(plain(t))
+	if err != nil {
+		return nil, err
+	}
+	return appendFields(data, t.Extensions)
+}
+
+func (t *VersionReportV2Target) UnmarshalJSON(data []byte) error {
+	type plain VersionReportV2Target
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	extensions, err := unknownFields(data, versionReportV2TargetFields)
+	if err != nil {
+		return err
+	}
+	*t = VersionReportV2Target(p)
+	t.Extensions = extensions
+	return nil
+}
@@ -0,0 +1,132 @@
+package versioning
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeRecord(t *testing.T) {
+	data, err := encodeRecord(VersionReport{Key: "test", BumpType: BumpMinor}, ReportKindV1)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), `{"_envelope":{"schema_version":2,"kind":"v1","written_at":"`), string(data))
+	assert.Contains(t, string(data), `"},"key":"test",`)
+
+	// Readers that predate the envelope still decode the payload.
+	type legacyVersionReport struct {
+		Key      string   `json:"key"`
+		BumpType BumpType `json:"bump_type"`
+	}
+	var legacy legacyVersionReport
+	require.NoError(t, json.Unmarshal(data, &legacy))
+	assert.Equal(t, legacyVersionReport{Key: "test", BumpType: BumpMinor}, legacy)
+}
+
+func TestDecodeRecordMigratesLegacyLines(t *testing.T) {
+	var report VersionReport
+	env, err := decodeRecord([]byte(`{"key":"legacy","priority":1}`), ReportKindV1, &report)
+	require.NoError(t, err)
+	assert.Equal(t, 1, env.SchemaVersion)
+	assert.Equal(t, VersionReport{Key: "legacy", Priority: 1, BumpType: BumpNone}, report)
+
+	_, err = decodeRecord([]byte(`{"key":"legacy","bump_type":""}`), ReportKindV1, &report)
+	require.NoError(t, err)
+	assert.Equal(t, BumpNone, report.BumpType)
+
+	var target VersionReportV2Target
+	_, err = decodeRecord([]byte(`{"target_name":"go","new_version":"1.0.0"}`), ReportKindV2, &target)
+	require.NoError(t, err)
+	assert.Equal(t, VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0"}, target)
+}
+
+func TestDecodeRecordRejectsWrongKind(t *testing.T) {
+	line, err := encodeRecord(VersionReportV2Target{TargetName: "go"}, ReportKindV2)
+	require.NoError(t, err)
+
+	var report VersionReport
+	_, err = decodeRecord(line, ReportKindV1, &report)
+	assert.Error(t, err)
+}
+
+func TestDecodeRecordRejectsInvalidSchemaVersion(t *testing.T) {
+	for _, line := range []string{
+		`{"_envelope":{"kind":"v1"},"key":"x"}`,
+		`{"_envelope":{"schema_version":0,"kind":"v1"},"key":"x"}`,
+		`{"_envelope":{"schema_version":-3,"kind":"v1"},"key":"x"}`,
+	} {
+		var report VersionReport
+		_, err := decodeRecord([]byte(line), ReportKindV1, &report)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestExtensionsSurviveRoundTrip(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	// Lines written by a newer version of this package, with fields this one
+	// does not know about.
+	v1 := `{"_envelope":{"schema_version":3,"kind":"v1"},"key":"future","bump_type":"minor","severity":{"level":3},"owners":["a","b"]}` + "\n"
+	v2 := `{"_envelope":{"schema_version":3,"kind":"v2"},"target_name":"go","new_version":"2.0.0","operations":[],"sdk_flavor":"lite"}` + "\n"
+
+	ctx := context.Background()
+	type unknown struct{}
+	outer, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+		inner, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+			location := os.Getenv(ENV_VAR_PREFIX)
+			if err := os.WriteFile(location, []byte(v1), 0644); err != nil {
+				return nil, err
+			}
+			return nil, os.WriteFile(v2LocationFor(location), []byte(v2), 0644)
+		})
+		require.NoError(t, err)
+		require.Len(t, inner.V1.Reports, 1)
+		return nil, nil
+	})
+
+	// The records were decoded by the inner capture and written back to the
+	// outer one by this version of the package.
+	require.NoError(t, err)
+	require.Len(t, outer.V1.Reports, 1)
+	report := outer.V1.Reports[0]
+	assert.Equal(t, "future", report.Key)
+	assert.Equal(t, BumpMinor, report.BumpType)
+	assert.Equal(t, map[string]json.RawMessage{
+		"owners":   json.RawMessage(`["a","b"]`),
+		"severity": json.RawMessage(`{"level":3}`),
+	}, report.Extensions)
+
+	require.NotNil(t, outer.V2)
+	assert.Equal(t, map[string]json.RawMessage{"sdk_flavor": json.RawMessage(`"lite"`)}, outer.V2.Targets[0].Extensions)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"owners":["a","b"],"severity":{"level":3}}`)
+}
+
+func TestFileSinkWritesEnvelope(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	ctx := context.Background()
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test"}))
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+
+	for path, kind := range map[string]ReportKind{location: ReportKindV1, sink.V2Location: ReportKindV2} {
+		contents, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var header struct {
+			Envelope envelope `json:"_envelope"`
+		}
+		require.NoError(t, json.Unmarshal(contents, &header))
+		assert.Equal(t, CurrentSchemaVersion, header.Envelope.SchemaVersion)
+		assert.Equal(t, kind, header.Envelope.Kind)
+		assert.WithinDuration(t, time.Now(), header.Envelope.WrittenAt, time.Minute)
+	}
+}
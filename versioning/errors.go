@@ -0,0 +1,88 @@
+package versioning
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrLocationNotSet is returned when reports are read but no destination
+	// was ever configured, neither in the context nor in ENV_VAR_PREFIX.
+	ErrLocationNotSet = errors.New(ENV_VAR_PREFIX + " is not set")
+
+	// ErrCorruptReport matches every error caused by a stored record that
+	// could not be decoded, such as a *DecodeError.
+	ErrCorruptReport = errors.New("corrupt version report")
+
+	// ErrUnsafeReportFile is returned instead of opening a report file that
+	// is a symlink, not a regular file, or, for writes, owned by another user.
+	ErrUnsafeReportFile = errors.New("unsafe version report file")
+
+	// ErrLimitExceeded matches every *LimitError.
+	ErrLimitExceeded = errors.New("version report limit exceeded")
+
+	// ErrMergeConflict matches every *MergeConflictError.
+	ErrMergeConflict = errors.New("conflicting version reports")
+
+	// ErrWatchNotSupported is returned by Watch for sinks that do not
+	// implement Watcher, such as a SocketSink.
+	ErrWatchNotSupported = errors.New("version reports cannot be watched")
+
+	// ErrInvalidVersion is returned for versions that are not valid semantic
+	// versions.
+	ErrInvalidVersion = errors.New("invalid semantic version")
+
+	// ErrInvalidBump is returned when a next version cannot be computed for a
+	// BumpType, such as BumpCustom, which needs an explicit version.
+	ErrInvalidBump = errors.New("invalid version bump")
+
+	// ErrVersionConflict matches every *VersionConflictError.
+	ErrVersionConflict = errors.New("version reports disagree")
+)
+
+// ReportKind identifies the format of a stored record.
+type ReportKind string
+
+const (
+	ReportKindV1 ReportKind = "v1"
+	ReportKindV2 ReportKind = "v2"
+)
+
+// DecodeError describes a stored record that could not be decoded. It matches
+// ErrCorruptReport with errors.Is.
+type DecodeError struct {
+	Path   string     // file the record was read from
+	Line   int        // 1-based line number
+	Offset int64      // byte offset of the start of the line
+	Kind   ReportKind // whether a V1 report or a V2 target was expected
+	Err    error      // underlying decoding error
+
+	snippet string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s report at %s:%d: %v", e.Kind, e.Path, e.Line, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == ErrCorruptReport
+}
+
+// joinErrors is like errors.Join, except that a single non-nil error is
+// returned as is.
+func joinErrors(errs ...error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	if len(nonNil) == 1 {
+		return nonNil[0]
+	}
+	return errors.Join(nonNil...)
+}
@@ -0,0 +1,65 @@
+package versioning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrLocationNotSet(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	_, err := getMergedVersionReport()
+	assert.ErrorIs(t, err, ErrLocationNotSet)
+	assert.EqualError(t, err, "SPEAKEASY_VERSION_REPORT_LOCATION is not set")
+
+	_, err = ReadVersionReports(context.Background())
+	assert.ErrorIs(t, err, ErrLocationNotSet)
+	assert.NotErrorIs(t, err, ErrCorruptReport)
+}
+
+func TestDecodeError(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	require.NoError(t, os.WriteFile(location, []byte("{\"key\":\"test\"}\n{\"key\":\n"), 0644))
+	require.NoError(t, os.WriteFile(v2LocationFor(location), []byte("\n\n[1, 2]\n"), 0644))
+
+	sink := NewFileSink(location)
+	ctx := context.Background()
+
+	_, err := sink.ReadVersionReports(ctx)
+	assert.ErrorIs(t, err, ErrCorruptReport)
+	assert.NotErrorIs(t, err, ErrLocationNotSet)
+
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, location, decodeErr.Path)
+	assert.Equal(t, 2, decodeErr.Line)
+	assert.Equal(t, int64(15), decodeErr.Offset)
+	assert.Equal(t, ReportKindV1, decodeErr.Kind)
+
+	var syntaxErr *json.SyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+
+	_, err = sink.ReadVersionReportV2Targets(ctx)
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, v2LocationFor(location), decodeErr.Path)
+	assert.Equal(t, 3, decodeErr.Line)
+	assert.Equal(t, ReportKindV2, decodeErr.Kind)
+
+	var typeErr *json.UnmarshalTypeError
+	assert.ErrorAs(t, err, &typeErr)
+
+	// Lenient reads surface the same errors through their diagnostics.
+	capture, err := ReadVersionReports(WithDestination(ctx, location), WithLenientDecoding())
+	require.NoError(t, err)
+	require.Len(t, capture.Diagnostics, 2)
+	for _, diagnostic := range capture.Diagnostics {
+		assert.True(t, errors.Is(diagnostic.Err, ErrCorruptReport))
+	}
+}
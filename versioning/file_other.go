@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package versioning
+
+import "os"
+
+// Symlinks are still refused by openLocked after opening, and file ownership
+// cannot be checked portably on this platform.
+
+const noFollow = 0
+
+func checkOwner(location string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,103 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefusesSymlinkedReportFiles(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "elsewhere")
+	require.NoError(t, os.WriteFile(target, []byte(`{"key":"planted"}`+"\n"), 0644))
+	location := filepath.Join(dir, "version.json")
+	require.NoError(t, os.Symlink(target, location))
+
+	sink := NewFileSink(location)
+	err := sink.WriteVersionReport(context.Background(), VersionReport{Key: "test"})
+	assert.ErrorIs(t, err, ErrUnsafeReportFile)
+	_, err = sink.ReadVersionReports(context.Background())
+	assert.ErrorIs(t, err, ErrUnsafeReportFile)
+
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, `{"key":"planted"}`+"\n", string(data))
+
+	require.NoError(t, os.Symlink(target, sink.V2Location))
+	err = sink.WriteVersionReportV2Target(context.Background(), VersionReportV2Target{TargetName: "go"})
+	assert.ErrorIs(t, err, ErrUnsafeReportFile)
+}
+
+func TestRefusesFilesOwnedByOthers(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file ownership requires root")
+	}
+
+	location := filepath.Join(t.TempDir(), "version.json")
+	require.NoError(t, os.WriteFile(location, nil, 0666))
+	require.NoError(t, os.Chown(location, 65534, 65534))
+
+	err := NewFileSink(location).WriteVersionReport(context.Background(), VersionReport{Key: "test"})
+	assert.ErrorIs(t, err, ErrUnsafeReportFile)
+	_, err = NewFileSink(location).ReadVersionReports(context.Background())
+	assert.NoError(t, err, "reading a file owned by someone else is allowed")
+}
+
+func TestFileMode(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	sink.FileMode = 0640
+	require.NoError(t, sink.WriteVersionReport(context.Background(), VersionReport{Key: "test"}))
+
+	info, err := os.Stat(location)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	dir := filepath.Join(t.TempDir(), "reports")
+	dirSink := NewDirSink(dir)
+	dirSink.FileMode = 0600
+	require.NoError(t, dirSink.WriteVersionReport(context.Background(), VersionReport{Key: "test"}))
+	info, err = os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
+
+func TestCaptureFilesArePrivate(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	os.Unsetenv(FILE_MODE_ENV_VAR)
+
+	var location string
+	_, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		location = os.Getenv(ENV_VAR_PREFIX)
+		assert.Equal(t, "600", os.Getenv(FILE_MODE_ENV_VAR))
+
+		info, err := os.Stat(location)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		info, err = os.Stat(filepath.Dir(location))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+		require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+		info, err = os.Stat(getV2Location())
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		return true, nil
+	})
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Dir(location))
+	assert.True(t, os.IsNotExist(err), "the capture directory is removed")
+
+	_, _, err = WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		info, err := os.Stat(os.Getenv(ENV_VAR_PREFIX))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		return true, nil
+	}, WithFileMode(0640))
+	require.NoError(t, err)
+}
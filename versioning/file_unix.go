@@ -0,0 +1,26 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package versioning
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// noFollow makes opening a report file fail if its last path element is a
+// symlink.
+const noFollow = syscall.O_NOFOLLOW
+
+// checkOwner refuses files owned by another user, which could have been
+// planted to collect what we write.
+func checkOwner(location string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%w: %s is owned by uid %d", ErrUnsafeReportFile, location, stat.Uid)
+	}
+	return nil
+}
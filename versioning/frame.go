@@ -0,0 +1,68 @@
+package versioning
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+)
+
+// FRAMED_ENV_VAR makes writers use the framed record format. Readers accept
+// framed and plain lines regardless of it.
+const FRAMED_ENV_VAR = "SPEAKEASY_VERSION_REPORT_FRAMED"
+
+// ErrCorruptFrame is wrapped by the DecodeError of a framed record whose
+// length or checksum does not match its payload, which happens when a write
+// was torn or interleaved with another one.
+var ErrCorruptFrame = errors.New("corrupt record frame")
+
+// A framed record is a single line of the form
+//
+//	#<payload length>,<CRC-32C of the payload as 8 hex digits>,<payload>
+//
+// Plain JSON lines always start with '{', so both formats can be mixed in
+// one file.
+const frameMarker = '#'
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// frameRecord wraps payload in a frame.
+func frameRecord(payload []byte) []byte {
+	header := fmt.Sprintf("%c%d,%08x,", frameMarker, len(payload), crc32.Checksum(payload, crcTable))
+	return append([]byte(header), payload...)
+}
+
+// unframeRecord returns the payload of a framed line after checking its length
+// and checksum. Lines that are not framed are returned unchanged.
+func unframeRecord(line []byte) ([]byte, error) {
+	if len(line) == 0 || line[0] != frameMarker {
+		return line, nil
+	}
+
+	lengthField, rest, ok := bytes.Cut(line[1:], []byte{','})
+	if !ok {
+		return nil, fmt.Errorf("%w: missing length", ErrCorruptFrame)
+	}
+	checksumField, payload, ok := bytes.Cut(rest, []byte{','})
+	if !ok {
+		return nil, fmt.Errorf("%w: missing checksum", ErrCorruptFrame)
+	}
+
+	length, err := strconv.Atoi(string(lengthField))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid length %q", ErrCorruptFrame, lengthField)
+	}
+	checksum, err := strconv.ParseUint(string(checksumField), 16, 32)
+	if err != nil || len(checksumField) != 8 {
+		return nil, fmt.Errorf("%w: invalid checksum %q", ErrCorruptFrame, checksumField)
+	}
+
+	if len(payload) != length {
+		return nil, fmt.Errorf("%w: expected %d payload bytes, found %d", ErrCorruptFrame, length, len(payload))
+	}
+	if actual := crc32.Checksum(payload, crcTable); actual != uint32(checksum) {
+		return nil, fmt.Errorf("%w: expected checksum %08x, computed %08x", ErrCorruptFrame, checksum, actual)
+	}
+	return payload, nil
+}
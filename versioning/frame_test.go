@@ -0,0 +1,104 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFramedRecordsRoundTrip(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := &FileSink{Location: location, V2Location: v2LocationFor(location), FileOptions: FileOptions{Framed: true}}
+	ctx := WithRecorder(context.Background(), NewRecorder(sink))
+
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "test", Priority: 1, PRReport: "Test report"}))
+	require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "typescript", NewVersion: "1.0.0"}))
+
+	data, err := os.ReadFile(location)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "#"))
+
+	capture, err := ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.Equal(t, "Test report", capture.V1.Reports[0].PRReport)
+	require.NotNil(t, capture.V2)
+	assert.Equal(t, "1.0.0", capture.V2.Targets[0].NewVersion)
+}
+
+func TestUnframeRecord(t *testing.T) {
+	payload := []byte(`{"key":"test"}`)
+	framed := frameRecord(payload)
+
+	out, err := unframeRecord(framed)
+	require.NoError(t, err)
+	assert.Equal(t, payload, out)
+
+	out, err = unframeRecord(payload)
+	require.NoError(t, err)
+	assert.Equal(t, payload, out)
+
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "truncated", line: string(framed[:len(framed)-3])},
+		{name: "flipped byte", line: strings.Replace(string(framed), "test", "tesT", 1)},
+		{name: "missing checksum", line: "#14"},
+		{name: "bad length", line: "#x,00000000,{}"},
+		{name: "bad checksum", line: "#2,zz,{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := unframeRecord([]byte(tt.line))
+			assert.ErrorIs(t, err, ErrCorruptFrame)
+		})
+	}
+}
+
+func TestCorruptFramesAreReported(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	good := frameRecord([]byte(`{"key":"test1","priority":1,"pr_report":"Test report 1"}`))
+	// A frame whose payload was replaced by another writer's bytes still
+	// decodes as JSON, and must be rejected by its checksum.
+	torn := strings.Replace(string(good), "test1", "test2", 1)
+	data := string(good) + "\n" + torn + "\n" + string(good[:20]) + "\n"
+	require.NoError(t, os.WriteFile(location, []byte(data), 0644))
+
+	sink := NewFileSink(location)
+	_, err := sink.ReadVersionReports(context.Background())
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, 2, decodeErr.Line)
+	assert.Equal(t, int64(len(good)+1), decodeErr.Offset)
+	assert.ErrorIs(t, err, ErrCorruptReport)
+	assert.ErrorIs(t, err, ErrCorruptFrame)
+
+	reports, diagnostics, err := sink.ReadVersionReportsLenient(context.Background())
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, "test1", reports[0].Key)
+	require.Len(t, diagnostics, 2)
+	assert.Equal(t, 2, diagnostics[0].Line)
+	assert.Equal(t, 3, diagnostics[1].Line)
+	assert.True(t, errors.Is(diagnostics[1].Err, ErrCorruptFrame))
+}
+
+func TestWithFramedRecordsCapture(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	os.Unsetenv(FRAMED_ENV_VAR)
+
+	capture, _, err := WithVersionReportCapture[bool](context.Background(), func(ctx context.Context) (bool, error) {
+		assert.Equal(t, "true", os.Getenv(FRAMED_ENV_VAR))
+		return true, AddVersionReport(ctx, VersionReport{Key: "test", Priority: 1, PRReport: "Test report"})
+	}, WithFramedRecords())
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.Equal(t, "", os.Getenv(FRAMED_ENV_VAR))
+}
@@ -0,0 +1,95 @@
+package versioning
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReadIndex returns the position of r among all the reports read, starting at
+// 0, which is the order in which they were written. Reports that were not read
+// back from a sink have an index of 0.
+func (r VersionReport) ReadIndex() int {
+	return r.readIndex
+}
+
+// WithHistory makes the capture, or read, keep every V1 report it reads, by
+// key, for MergedVersionReport.History and GetAuditSection. Without it only
+// the merged reports are kept, so memory does not grow with the report file.
+//
+// The files of a capture made WithHistory, and of the captures nested in it,
+// are not compacted automatically, and Compact keeps all their V1 reports,
+// so its history is complete unless a subprocess calls Compact itself. A
+// read only sees the reports still stored: compacting a file, with Compact
+// or automatically (see FileOptions.CompactThreshold), drops superseded
+// reports from it.
+func WithHistory() CaptureOption {
+	return func(o *captureOptions) {
+		o.history = true
+	}
+}
+
+// History returns every report recorded for key, including those superseded
+// while merging, in the order they were written, except those dropped by
+// compacting a file before it was read. It is only kept for captures and
+// reads made WithHistory, and is nil for unknown keys.
+func (m *MergedVersionReport) History(key string) []VersionReport {
+	history := m.history[key]
+	if history == nil {
+		return nil
+	}
+	return append([]VersionReport(nil), history...)
+}
+
+// GetAuditSection renders the history of every key, in the order of Reports,
+// showing each report's read index and priority, and whether it is the one
+// the merge kept. Without a history, only the kept reports are shown.
+func (m *MergedVersionReport) GetAuditSection() string {
+	var b strings.Builder
+	for _, kept := range m.Reports {
+		strategy := m.Strategies[kept.Key]
+		if len(strategy) == 0 {
+			strategy = MergeLastWins
+		}
+		fmt.Fprintf(&b, "%s (%s)\n", kept.Key, strategy)
+
+		history := m.history[kept.Key]
+		if history == nil {
+			history = []VersionReport{kept}
+		}
+		for _, report := range history {
+			outcome := "superseded"
+			if report.readIndex == kept.readIndex {
+				outcome = "kept"
+			}
+			fmt.Fprintf(&b, "  #%d priority %d: %s, bump %s", report.readIndex, report.Priority, outcome, report.BumpType)
+			if len(report.NewVersion) > 0 {
+				fmt.Fprintf(&b, ", version %s", report.NewVersion)
+			}
+			if writer := describeWriter(report); len(writer) > 0 {
+				fmt.Fprintf(&b, ", written by %s", writer)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// describeWriter names the process that wrote report, if known, for example
+// "generate go (pid 42, speakeasy 1.2.3)".
+func describeWriter(report VersionReport) string {
+	name := strings.Join(report.command, " ")
+	var details []string
+	if p := report.provenance; p != nil {
+		if len(name) == 0 {
+			name = p.Executable
+		}
+		details = append(details, fmt.Sprintf("pid %d", p.Pid))
+		if len(p.Tool) > 0 {
+			details = append(details, strings.TrimSpace(p.Tool+" "+p.ToolVersion))
+		}
+	}
+	if len(details) == 0 {
+		return name
+	}
+	return strings.TrimSpace(name + " (" + strings.Join(details, ", ") + ")")
+}
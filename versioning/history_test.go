@@ -0,0 +1,95 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	merged, err := mergeVersionReports([]VersionReport{
+		{Key: "sdk", Priority: 1, BumpType: BumpMinor, NewVersion: "1.3.0"},
+		{Key: "docs", BumpType: BumpPatch},
+		{Key: "sdk", Priority: 2, BumpType: BumpMajor, NewVersion: "2.0.0"},
+	}, mergeStrategies{"docs": MergeFirstWins})
+	require.NoError(t, err)
+
+	history := merged.History("sdk")
+	require.Len(t, history, 2)
+	assert.Equal(t, 0, history[0].ReadIndex())
+	assert.Equal(t, "1.3.0", history[0].NewVersion)
+	assert.Equal(t, 2, history[1].ReadIndex())
+	assert.Equal(t, "2.0.0", history[1].NewVersion)
+	assert.Nil(t, merged.History("python"))
+
+	// The history is a copy.
+	history[0].Key = "changed"
+	assert.Equal(t, "sdk", merged.History("sdk")[0].Key)
+
+	assert.Equal(t, `sdk (last_wins)
+  #0 priority 1: superseded, bump minor, version 1.3.0
+  #2 priority 2: kept, bump major, version 2.0.0
+docs (first_wins)
+  #1 priority 0: kept, bump patch
+`, merged.GetAuditSection())
+}
+
+func TestHistoryFromCapture(t *testing.T) {
+	SetTool("speakeasy", "1.2.3")
+	t.Cleanup(func() { SetTool("", "") })
+
+	recorder := NewRecorder(NewMemorySink())
+	capture, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "sdk", PRReport: "First"}))
+		return AddVersionReport(ctx, VersionReport{Key: "sdk", PRReport: "Second", command: []string{"generate", "go"}})
+	}, WithMergeStrategy("sdk", MergeFirstWins), WithHistory())
+	require.NoError(t, err)
+
+	history := capture.V1.History("sdk")
+	require.Len(t, history, 2)
+	assert.Equal(t, "First", history[0].PRReport)
+	assert.Equal(t, "Second", history[1].PRReport)
+
+	audit := capture.V1.GetAuditSection()
+	assert.Contains(t, audit, "sdk (first_wins)\n  #0 priority 0: kept, bump none, written by versioning.test (pid ")
+	assert.Contains(t, audit, "  #1 priority 0: superseded, bump none, written by generate go (pid ")
+	assert.Contains(t, audit, ", speakeasy 1.2.3)\n")
+
+	// Only the merged reports are kept by default.
+	capture, err = recorder.Read(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, capture.V1.History("sdk"))
+	assert.Contains(t, capture.V1.GetAuditSection(), "sdk (last_wins)\n  #1 priority 0: kept")
+}
+
+func TestHistoryWithCompaction(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	t.Setenv(COMPACT_THRESHOLD_ENV_VAR, "1")
+	var compacted *VersionReportCapture
+	write := func(ctx context.Context) (bool, error) {
+		for i := 0; i < 3; i++ {
+			require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "sdk", NewVersion: "1.0.0"}))
+		}
+		require.NoError(t, Compact(ctx))
+		var err error
+		compacted, err = ReadVersionReports(ctx, WithHistory())
+		return true, err
+	}
+
+	capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		_, ok := os.LookupEnv(COMPACT_THRESHOLD_ENV_VAR)
+		assert.False(t, ok, "subprocesses do not compact the capture's files")
+		return write(ctx)
+	}, WithHistory())
+	require.NoError(t, err)
+	assert.Len(t, capture.V1.History("sdk"), 3)
+	assert.Len(t, compacted.V1.History("sdk"), 3)
+
+	// Otherwise superseded reports are compacted away before they are read.
+	_, _, err = WithVersionReportCapture(context.Background(), write)
+	require.NoError(t, err)
+	assert.Len(t, compacted.V1.History("sdk"), 1)
+}
@@ -0,0 +1,369 @@
+package versioning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// LIMITS_ENV_VAR holds the JSON encoded Limits applied by subprocesses.
+const LIMITS_ENV_VAR = "SPEAKEASY_VERSION_REPORT_LIMITS"
+
+// OverflowPolicy decides what happens to a record that exceeds a limit.
+type OverflowPolicy string
+
+const (
+	// OverflowReject fails the write with a *LimitError. It is the default.
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowTruncate shrinks the record until it fits and describes what
+	// was removed in its Overflow field. V1 reports lose the end of their
+	// longest text, which is replaced by truncationMarker; V2 targets lose
+	// their last operations.
+	OverflowTruncate OverflowPolicy = "truncate"
+	// OverflowSpill truncates the record like OverflowTruncate, after saving
+	// the complete record to a side file named in its Overflow field.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// truncationMarker ends every text shortened by OverflowTruncate or
+// OverflowSpill.
+const truncationMarker = "\n\n[truncated]"
+
+// Limits bounds what FileSink and DirSink write, and what captures kept in
+// memory record. Zero values disable a limit. Limits apply to every record
+// kind alike, except MaxOperations, which only concerns V2 targets, and
+// MaxFileSize, which only bounds files.
+type Limits struct {
+	// MaxRecordSize is the maximum size in bytes of a stored record.
+	MaxRecordSize int64          `json:"max_record_size,omitempty"`
+	RecordPolicy  OverflowPolicy `json:"record_policy,omitempty"`
+
+	// MaxFileSize is the maximum size in bytes of a report file.
+	MaxFileSize int64          `json:"max_file_size,omitempty"`
+	FilePolicy  OverflowPolicy `json:"file_policy,omitempty"`
+
+	// MaxOperations is the maximum number of operations of a V2 target.
+	MaxOperations    int            `json:"max_operations,omitempty"`
+	OperationsPolicy OverflowPolicy `json:"operations_policy,omitempty"`
+
+	// SpillDir holds the side files of OverflowSpill. It defaults to the
+	// directory of the report file; inside a capture, to that of the
+	// enclosing report files, as the capture's own are removed with it.
+	SpillDir string `json:"spill_dir,omitempty"`
+}
+
+// ReportOverflow describes a record that was shrunk to fit the Limits.
+type ReportOverflow struct {
+	// Limit names the limit that was exceeded: "record_size", "file_size"
+	// or "operations".
+	Limit string `json:"limit"`
+	// OmittedBytes counts the bytes of V1 report text that were removed.
+	OmittedBytes int `json:"omitted_bytes,omitempty"`
+	// OmittedOperations counts the V2 operations that were removed.
+	OmittedOperations int `json:"omitted_operations,omitempty"`
+	// File is the side file holding the complete record, if it was spilled.
+	File string `json:"file,omitempty"`
+}
+
+// LimitError is returned when a record exceeds a limit under OverflowReject,
+// or cannot be shrunk enough to fit. It matches ErrLimitExceeded with
+// errors.Is.
+type LimitError struct {
+	Limit string     // "record_size", "file_size" or "operations"
+	Kind  ReportKind // kind of the rejected record
+	Size  int64      // size of the record, or its number of operations
+	Max   int64      // the limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s report exceeds the %s limit: %d > %d", e.Kind, strings.ReplaceAll(e.Limit, "_", " "), e.Size, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// WithLimits sets the Limits of the capture's reports, instead of those of the
+// enclosing capture or LIMITS_ENV_VAR, and passes them on to subprocesses
+// writing files. Captures kept in memory, such as those made
+// WithSocketCollector, apply them to every report they receive. Spilled
+// records have to outlive the capture, so without an enclosing capture that
+// writes files, OverflowSpill needs a SpillDir or the capture fails to start.
+func WithLimits(limits Limits) CaptureOption {
+	return func(o *captureOptions) {
+		o.limits = &limits
+	}
+}
+
+// spills reports whether any limit is enforced with OverflowSpill.
+func (l Limits) spills() bool {
+	return (l.MaxRecordSize > 0 && l.RecordPolicy == OverflowSpill) ||
+		(l.MaxFileSize > 0 && l.FilePolicy == OverflowSpill) ||
+		(l.MaxOperations > 0 && l.OperationsPolicy == OverflowSpill)
+}
+
+// limitedSink applies the record limits of a FileSink to a MemorySink, which
+// stores no file for them to bound.
+type limitedSink struct {
+	*MemorySink
+	limits *FileSink
+}
+
+// limitMemorySink returns sink, limited by limits if they bound records.
+func limitMemorySink(sink *MemorySink, limits Limits) ReportSink {
+	if limits.MaxRecordSize <= 0 && limits.MaxOperations <= 0 {
+		return sink
+	}
+	return &limitedSink{MemorySink: sink, limits: &FileSink{FileOptions: FileOptions{Limits: limits}}}
+}
+
+func (s *limitedSink) WriteVersionReport(ctx context.Context, report VersionReport) error {
+	// The location only names the files records are spilled to.
+	report, _, err := encodeLimited(s.limits, filepath.Join(s.limits.Limits.SpillDir, "version.json"), ReportKindV1, report)
+	if err != nil {
+		return err
+	}
+	return s.MemorySink.WriteVersionReport(ctx, report)
+}
+
+func (s *limitedSink) WriteVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
+	location := filepath.Join(s.limits.Limits.SpillDir, "version.v2.json")
+	target, err := s.limits.limitOperations(location, target)
+	if err != nil {
+		return err
+	}
+	if target, _, err = encodeLimited(s.limits, location, ReportKindV2, target); err != nil {
+		return err
+	}
+	return s.MemorySink.WriteVersionReportV2Target(ctx, target)
+}
+
+// LoadSpilledVersionReport returns the complete report that report was
+// truncated from, or report itself if it was not spilled.
+func LoadSpilledVersionReport(report VersionReport) (VersionReport, error) {
+	if report.Overflow == nil || len(report.Overflow.File) == 0 {
+		return report, nil
+	}
+	var complete VersionReport
+	return complete, loadSpilled(report.Overflow.File, ReportKindV1, &complete)
+}
+
+// LoadSpilledVersionReportV2Target returns the complete target that target
+// was truncated from, or target itself if it was not spilled.
+func LoadSpilledVersionReportV2Target(target VersionReportV2Target) (VersionReportV2Target, error) {
+	if target.Overflow == nil || len(target.Overflow.File) == 0 {
+		return target, nil
+	}
+	var complete VersionReportV2Target
+	return complete, loadSpilled(target.Overflow.File, ReportKindV2, &complete)
+}
+
+func loadSpilled(location string, kind ReportKind, record any) error {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return err
+	}
+	if _, err := decodeRecord(data, kind, record); err != nil {
+		return &DecodeError{Path: location, Line: 1, Kind: kind, Err: err}
+	}
+	return nil
+}
+
+// limitable is implemented by the record types that Limits can shrink.
+type limitable[T any] interface {
+	*T
+	overflow() **ReportOverflow
+	// trim shrinks the record by at least excess encoded bytes if it can,
+	// and reports whether anything was removed.
+	trim(excess int) bool
+}
+
+func (r *VersionReport) overflow() **ReportOverflow {
+	return &r.Overflow
+}
+
+// trim shortens the longest of PRReport and CommitReport.
+func (r *VersionReport) trim(excess int) bool {
+	text := &r.PRReport
+	if len(strings.TrimSuffix(r.CommitReport, truncationMarker)) > len(strings.TrimSuffix(r.PRReport, truncationMarker)) {
+		text = &r.CommitReport
+	}
+	body := strings.TrimSuffix(*text, truncationMarker)
+	if len(body) == 0 {
+		return false
+	}
+
+	keep := len(body) - excess - len(truncationMarker)
+	if keep < 0 {
+		keep = 0
+	}
+	for keep > 0 && !utf8.RuneStart(body[keep]) {
+		keep--
+	}
+	*text = body[:keep] + truncationMarker
+	r.Overflow.OmittedBytes += len(body) - keep
+	return true
+}
+
+func (t *VersionReportV2Target) overflow() **ReportOverflow {
+	return &t.Overflow
+}
+
+// trim drops the last operation.
+func (t *VersionReportV2Target) trim(excess int) bool {
+	if len(t.Operations) == 0 {
+		return false
+	}
+	t.Operations = t.Operations[:len(t.Operations)-1]
+	t.Overflow.OmittedOperations++
+	return true
+}
+
+// encode returns the stored form of record, without its trailing newline.
+func (s *FileSink) encode(record any, kind ReportKind) ([]byte, error) {
+	data, err := encodeRecord(record, kind)
+	if err != nil {
+		return nil, err
+	}
+	if s.Framed {
+		data = frameRecord(data)
+	}
+	return data, nil
+}
+
+// fit encodes record, shrinking it to at most max bytes according to policy.
+// A max of zero or less means no limit.
+func fit[T any, P limitable[T]](s *FileSink, location string, kind ReportKind, record T, limit string, max int64, policy OverflowPolicy) (T, []byte, error) {
+	data, err := s.encode(record, kind)
+	if err != nil || max <= 0 || int64(len(data)) <= max {
+		return record, data, err
+	}
+	if err := overflowRecord[T, P](s, location, kind, &record, limit, policy, &LimitError{Limit: limit, Kind: kind, Size: int64(len(data)), Max: max}); err != nil {
+		return record, nil, err
+	}
+
+	for int64(len(data)) > max {
+		if !P(&record).trim(int(int64(len(data)) - max)) {
+			return record, nil, &LimitError{Limit: limit, Kind: kind, Size: int64(len(data)), Max: max}
+		}
+		if data, err = s.encode(record, kind); err != nil {
+			return record, nil, err
+		}
+	}
+	return record, data, nil
+}
+
+// overflowRecord prepares record for being shrunk, or returns rejection if
+// policy does not allow it. A spilled record is saved before it is shrunk.
+func overflowRecord[T any, P limitable[T]](s *FileSink, location string, kind ReportKind, record *T, limit string, policy OverflowPolicy, rejection *LimitError) error {
+	if policy != OverflowTruncate && policy != OverflowSpill {
+		return rejection
+	}
+
+	overflow := P(record).overflow()
+	var file string
+	if policy == OverflowSpill && (*overflow == nil || len((*overflow).File) == 0) {
+		var err error
+		if file, err = s.spill(location, kind, *record); err != nil {
+			return err
+		}
+	}
+
+	if *overflow == nil {
+		*overflow = &ReportOverflow{}
+	} else {
+		copied := **overflow
+		*overflow = &copied
+	}
+	(*overflow).Limit = limit
+	if len(file) > 0 {
+		(*overflow).File = file
+	}
+	return nil
+}
+
+// spill saves record to a new side file and returns its path.
+func (s *FileSink) spill(location string, kind ReportKind, record any) (string, error) {
+	data, err := encodeRecord(record, kind)
+	if err != nil {
+		return "", err
+	}
+
+	dir := s.Limits.SpillDir
+	if len(dir) == 0 {
+		dir = filepath.Dir(location)
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(location)+".overflow-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to create overflow file: %w", err)
+	}
+	defer f.Close()
+	if err := f.Chmod(s.fileMode()); err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		return "", fmt.Errorf("failed to write overflow file: %w", err)
+	}
+	return f.Name(), f.Close()
+}
+
+// limitOperations applies MaxOperations to target.
+func (s *FileSink) limitOperations(location string, target VersionReportV2Target) (VersionReportV2Target, error) {
+	max := s.Limits.MaxOperations
+	if max <= 0 || len(target.Operations) <= max {
+		return target, nil
+	}
+	rejection := &LimitError{Limit: "operations", Kind: ReportKindV2, Size: int64(len(target.Operations)), Max: int64(max)}
+	if err := overflowRecord(s, location, ReportKindV2, &target, "operations", s.Limits.OperationsPolicy, rejection); err != nil {
+		return target, err
+	}
+	target.Overflow.OmittedOperations += len(target.Operations) - max
+	target.Operations = target.Operations[:max]
+	return target, nil
+}
+
+// encodeLimited encodes record within MaxRecordSize.
+func encodeLimited[T any, P limitable[T]](s *FileSink, location string, kind ReportKind, record T) (T, []byte, error) {
+	return fit[T, P](s, location, kind, record, "record_size", s.Limits.MaxRecordSize, s.Limits.RecordPolicy)
+}
+
+// fitFile shrinks the encoded record so that appending it keeps the file f
+// within MaxFileSize. The caller holds the file's exclusive lock.
+func fitFile[T any, P limitable[T]](s *FileSink, f *os.File, location string, kind ReportKind, record T, data []byte) ([]byte, error) {
+	if s.Limits.MaxFileSize <= 0 {
+		return data, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// One byte of the remaining space goes to the newline.
+	remaining := s.Limits.MaxFileSize - info.Size() - 1
+	if int64(len(data)) <= remaining {
+		return data, nil
+	}
+	if remaining <= 0 {
+		return nil, &LimitError{Limit: "file_size", Kind: kind, Size: info.Size() + int64(len(data)) + 1, Max: s.Limits.MaxFileSize}
+	}
+	_, data, err = fit[T, P](s, location, kind, record, "file_size", remaining, s.Limits.FilePolicy)
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		limitErr.Size = info.Size() + limitErr.Size + 1
+		limitErr.Max = s.Limits.MaxFileSize
+	}
+	return data, err
+}
+
+// parseLimits decodes the value of LIMITS_ENV_VAR.
+func parseLimits(value string) Limits {
+	var limits Limits
+	if len(value) > 0 {
+		json.Unmarshal([]byte(value), &limits)
+	}
+	return limits
+}
@@ -0,0 +1,289 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func operations(n int) []VersionReportV2Operation {
+	ops := make([]VersionReportV2Operation, n)
+	for i := range ops {
+		ops[i] = VersionReportV2Operation{Name: fmt.Sprintf("sdk.operation%d()", i), Type: OperationAdded}
+	}
+	return ops
+}
+
+func TestRecordSizeLimitRejects(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	sink.Limits = Limits{MaxRecordSize: 256}
+	ctx := context.Background()
+
+	err := sink.WriteVersionReport(ctx, VersionReport{Key: "test", PRReport: strings.Repeat("x", 1024)})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	var limitErr *LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "record_size", limitErr.Limit)
+	assert.Equal(t, ReportKindV1, limitErr.Kind)
+	assert.Equal(t, int64(256), limitErr.Max)
+
+	err = sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", Operations: operations(50)})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+
+	_, err = os.Stat(location)
+	assert.True(t, os.IsNotExist(err), "nothing is written")
+}
+
+func TestRecordSizeLimitTruncates(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	sink.Limits = Limits{MaxRecordSize: 512, RecordPolicy: OverflowTruncate}
+	ctx := context.Background()
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{
+		Key:          "test",
+		PRReport:     strings.Repeat("é", 1000),
+		CommitReport: "short",
+	}))
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", Operations: operations(50)}))
+
+	data, err := os.ReadFile(location)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(data)-1, 512)
+
+	reports, err := sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	report := reports[0]
+	assert.True(t, strings.HasSuffix(report.PRReport, truncationMarker))
+	assert.True(t, utf8.ValidString(report.PRReport))
+	assert.Equal(t, "short", report.CommitReport)
+	require.NotNil(t, report.Overflow)
+	assert.Equal(t, "record_size", report.Overflow.Limit)
+	assert.Equal(t, 2000, len(strings.TrimSuffix(report.PRReport, truncationMarker))+report.Overflow.OmittedBytes)
+
+	targets, err := sink.ReadVersionReportV2Targets(ctx)
+	require.NoError(t, err)
+	target := targets[0]
+	require.NotNil(t, target.Overflow)
+	assert.Equal(t, 50, len(target.Operations)+target.Overflow.OmittedOperations)
+	assert.Equal(t, operations(len(target.Operations)), target.Operations)
+}
+
+func TestOperationsLimit(t *testing.T) {
+	ctx := context.Background()
+	target := VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0", Operations: operations(10)}
+
+	sink := NewFileSink(filepath.Join(t.TempDir(), "version.json"))
+	sink.Limits = Limits{MaxOperations: 3}
+	assert.ErrorIs(t, sink.WriteVersionReportV2Target(ctx, target), ErrLimitExceeded)
+
+	sink.Limits.OperationsPolicy = OverflowTruncate
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, target))
+	targets, err := sink.ReadVersionReportV2Targets(ctx)
+	require.NoError(t, err)
+	require.Len(t, targets[0].Operations, 3)
+	assert.Equal(t, &ReportOverflow{Limit: "operations", OmittedOperations: 7}, targets[0].Overflow)
+	assert.Len(t, target.Operations, 10, "the caller's target is not modified")
+}
+
+func TestSpillOverflow(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewFileSink(filepath.Join(dir, "version.json"))
+	sink.Limits = Limits{
+		MaxRecordSize:    1024,
+		RecordPolicy:     OverflowSpill,
+		MaxOperations:    3,
+		OperationsPolicy: OverflowSpill,
+	}
+	ctx := context.Background()
+
+	report := VersionReport{Key: "test", BumpType: BumpMinor, PRReport: strings.Repeat("x", 4096)}
+	target := VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0", Operations: operations(10)}
+	require.NoError(t, sink.WriteVersionReport(ctx, report))
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, target))
+
+	reports, err := sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, reports[0].Overflow)
+	assert.Equal(t, dir, filepath.Dir(reports[0].Overflow.File))
+	complete, err := LoadSpilledVersionReport(reports[0])
+	require.NoError(t, err)
+	assert.Equal(t, report.PRReport, complete.PRReport)
+	assert.Nil(t, complete.Overflow)
+
+	targets, err := sink.ReadVersionReportV2Targets(ctx)
+	require.NoError(t, err)
+	require.Len(t, targets[0].Operations, 3)
+	completeTarget, err := LoadSpilledVersionReportV2Target(targets[0])
+	require.NoError(t, err)
+	assert.Equal(t, target.Operations, completeTarget.Operations)
+
+	unspilled, err := LoadSpilledVersionReport(VersionReport{Key: "plain"})
+	require.NoError(t, err)
+	assert.Equal(t, "plain", unspilled.Key)
+}
+
+func TestFileSizeLimit(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	ctx := context.Background()
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "first", PRReport: "First report"}))
+	info, err := os.Stat(location)
+	require.NoError(t, err)
+	max := info.Size() + 512
+
+	sink.Limits = Limits{MaxFileSize: max}
+	err = sink.WriteVersionReport(ctx, VersionReport{Key: "second", PRReport: strings.Repeat("x", 1024)})
+	var limitErr *LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "file_size", limitErr.Limit)
+	assert.Equal(t, max, limitErr.Max)
+
+	sink.Limits.FilePolicy = OverflowTruncate
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "second", PRReport: strings.Repeat("x", 1024)}))
+	info, err = os.Stat(location)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), max)
+
+	reports, err := sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Equal(t, "second", reports[1].Key)
+	require.NotNil(t, reports[1].Overflow)
+	assert.Equal(t, "file_size", reports[1].Overflow.Limit)
+
+	// Once the file is full, even a truncated record no longer fits.
+	err = sink.WriteVersionReport(ctx, VersionReport{Key: "third"})
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+func TestLimitsEnviron(t *testing.T) {
+	limits := Limits{MaxRecordSize: 1024, RecordPolicy: OverflowTruncate, MaxOperations: 10, SpillDir: "/tmp/spill"}
+	recorder := NewRecorder(&FileSink{Location: "version.json", FileOptions: FileOptions{Limits: limits}})
+
+	for _, entry := range recorder.Environ() {
+		if name, value, _ := strings.Cut(entry, "="); name == LIMITS_ENV_VAR {
+			assert.Equal(t, limits, parseLimits(value))
+			return
+		}
+	}
+	t.Fatalf("%s is not exported", LIMITS_ENV_VAR)
+}
+
+func TestCaptureWithLimits(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	os.Unsetenv(LIMITS_ENV_VAR)
+	limits := Limits{MaxRecordSize: 512, RecordPolicy: OverflowTruncate}
+
+	capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		// Subprocesses are limited too.
+		assert.Equal(t, limits, parseLimits(os.Getenv(LIMITS_ENV_VAR)))
+		return true, AddVersionReport(ctx, VersionReport{Key: "test", PRReport: strings.Repeat("x", 10*1024)})
+	}, WithLimits(limits))
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.True(t, strings.HasSuffix(capture.V1.Reports[0].PRReport, truncationMarker))
+	require.NotNil(t, capture.V1.Reports[0].Overflow)
+
+	_, ok := os.LookupEnv(LIMITS_ENV_VAR)
+	assert.False(t, ok, "the environment is restored")
+}
+
+func TestCaptureInheritsLimitsFromEnviron(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	t.Setenv(LIMITS_ENV_VAR, `{"max_record_size":512,"record_policy":"truncate"}`)
+
+	capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		assert.Equal(t, Limits{MaxRecordSize: 512, RecordPolicy: OverflowTruncate}, parseLimits(os.Getenv(LIMITS_ENV_VAR)))
+		return true, AddVersionReport(ctx, VersionReport{Key: "test", PRReport: strings.Repeat("x", 10*1024)})
+	})
+	require.NoError(t, err)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.True(t, strings.HasSuffix(capture.V1.Reports[0].PRReport, truncationMarker))
+}
+
+func TestMemoryCaptureWithLimits(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	limits := Limits{MaxRecordSize: 512, RecordPolicy: OverflowTruncate, MaxOperations: 3, OperationsPolicy: OverflowTruncate}
+	report := VersionReport{Key: "test", PRReport: strings.Repeat("x", 10*1024)}
+	target := VersionReportV2Target{TargetName: "go", Operations: operations(10)}
+
+	tests := []struct {
+		name     string
+		recorder func(t *testing.T) *Recorder
+		opts     []CaptureOption
+		// write records through a socket, as a subprocess would.
+		socket bool
+	}{
+		{name: "memory", recorder: func(t *testing.T) *Recorder { return NewRecorder(NewMemorySink()) }},
+		{name: "configured memory", recorder: func(t *testing.T) *Recorder {
+			SetReportSink(NewMemorySink())
+			t.Cleanup(func() { SetReportSink(nil) })
+			return defaultRecorder
+		}},
+		{name: "socket", recorder: func(t *testing.T) *Recorder { return NewRecorder(NewMemorySink()) }, opts: []CaptureOption{WithSocketCollector()}, socket: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capture, err := tt.recorder(t).Capture(context.Background(), func(ctx context.Context) error {
+				sink := RecorderFromContext(ctx).reportSink()
+				if tt.socket {
+					_, address, _ := strings.Cut(RecorderFromContext(ctx).Environ()[0], "=")
+					sink = NewSocketSink(address)
+				}
+				require.NoError(t, sink.WriteVersionReport(ctx, report))
+				return sink.WriteVersionReportV2Target(ctx, target)
+			}, append(tt.opts, WithLimits(limits))...)
+			require.NoError(t, err)
+
+			require.Len(t, capture.V1.Reports, 1)
+			assert.True(t, strings.HasSuffix(capture.V1.Reports[0].PRReport, truncationMarker))
+			require.NotNil(t, capture.V1.Reports[0].Overflow)
+			require.NotNil(t, capture.V2)
+			assert.Len(t, capture.V2.Targets[0].Operations, 3)
+		})
+	}
+}
+
+func TestCaptureSpillDir(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	limits := Limits{MaxRecordSize: 512, RecordPolicy: OverflowSpill}
+	report := VersionReport{Key: "test", PRReport: strings.Repeat("x", 10*1024)}
+
+	// A top-level capture would spill into its own files, which are removed.
+	_, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		t.Fatal("the capture does not start")
+		return false, nil
+	}, WithLimits(limits))
+	assert.ErrorContains(t, err, "SpillDir")
+	_, err = NewRecorder(NewMemorySink()).Capture(context.Background(), func(ctx context.Context) error {
+		t.Fatal("the capture does not start")
+		return nil
+	}, WithLimits(limits))
+	assert.ErrorContains(t, err, "SpillDir")
+
+	for name, recorder := range map[string]*Recorder{"file": defaultRecorder, "memory": NewRecorder(NewMemorySink())} {
+		t.Run(name, func(t *testing.T) {
+			limits.SpillDir = t.TempDir()
+			capture, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+				return AddVersionReport(ctx, report)
+			}, WithLimits(limits))
+			require.NoError(t, err)
+
+			require.NotNil(t, capture.V1.Reports[0].Overflow)
+			assert.Equal(t, limits.SpillDir, filepath.Dir(capture.V1.Reports[0].Overflow.File))
+			complete, err := LoadSpilledVersionReport(capture.V1.Reports[0])
+			require.NoError(t, err, "the spilled record outlives the capture")
+			assert.Equal(t, report.PRReport, complete.PRReport)
+		})
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package versioning
+
+import "os"
+
+// Advisory locking is not available on this platform; writers within a single
+// process are still serialized by the package mutexes.
+
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
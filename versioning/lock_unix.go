@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package versioning
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory flock on f. Readers take a shared lock and
+// writers an exclusive one, so appends from other processes can never
+// interleave with a write or be observed half-written by a read.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
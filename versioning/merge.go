@@ -0,0 +1,181 @@
+package versioning
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// MERGE_STRATEGIES_ENV_VAR holds the JSON encoded FileOptions.MergeStrategies,
+// such as {"sdk":"first_wins","docs-*":"concatenate"}.
+const MERGE_STRATEGIES_ENV_VAR = "SPEAKEASY_VERSION_REPORT_MERGE_STRATEGIES"
+
+// MergeStrategy decides how V1 reports sharing a Key are merged.
+type MergeStrategy string
+
+const (
+	// MergeLastWins keeps the report written last. It is the default.
+	MergeLastWins MergeStrategy = "last_wins"
+	// MergeFirstWins keeps the report written first.
+	MergeFirstWins MergeStrategy = "first_wins"
+	// MergeHighestPriority keeps the report with the highest Priority, and
+	// the one written last among those.
+	MergeHighestPriority MergeStrategy = "highest_priority"
+	// MergeConcatenate keeps the report written last, with the PRReport and
+	// CommitReport texts of every report joined by newlines, and MustGenerate
+	// set if any report sets it.
+	MergeConcatenate MergeStrategy = "concatenate"
+	// MergeErrorOnConflict keeps the report written first and fails the merge
+	// with a *MergeConflictError if a later report differs from it.
+	MergeErrorOnConflict MergeStrategy = "error_on_conflict"
+)
+
+// MergeConflictError reports a second, different report for a key merged
+// with MergeErrorOnConflict. It matches ErrMergeConflict with errors.Is.
+type MergeConflictError struct {
+	Key string
+	// Kept is the report that was kept, and Rejected the one that differs.
+	Kept, Rejected VersionReport
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("conflicting version reports for key %q", e.Key)
+}
+
+func (e *MergeConflictError) Is(target error) bool {
+	return target == ErrMergeConflict
+}
+
+// mergeStrategies maps keys, or key prefixes ending in "*", to strategies.
+type mergeStrategies map[string]MergeStrategy
+
+// lookup returns the strategy registered for key. An exact key takes
+// precedence over prefixes, and longer prefixes over shorter ones.
+func (s mergeStrategies) lookup(key string) (MergeStrategy, bool) {
+	if strategy, ok := s[key]; ok {
+		return strategy, true
+	}
+	var found MergeStrategy
+	longest := -1
+	for pattern, strategy := range s {
+		prefix, isPrefix := strings.CutSuffix(pattern, "*")
+		if isPrefix && strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			found, longest = strategy, len(prefix)
+		}
+	}
+	return found, longest >= 0
+}
+
+var (
+	strategyMutex        sync.RWMutex
+	registeredStrategies = mergeStrategies{}
+)
+
+// SetMergeStrategy sets how reports are merged for key, or for every key
+// starting with the given prefix if key ends in "*". An empty strategy removes
+// the registration. Strategies passed to WithMergeStrategy take precedence.
+func SetMergeStrategy(key string, strategy MergeStrategy) {
+	strategyMutex.Lock()
+	defer strategyMutex.Unlock()
+	if len(strategy) == 0 {
+		delete(registeredStrategies, key)
+	} else {
+		registeredStrategies[key] = strategy
+	}
+}
+
+// WithMergeStrategy sets how the reports of a single capture or read are merged
+// for key, or for every key starting with the given prefix if key ends in "*".
+// A capture also adds it to the FileOptions.MergeStrategies of its files, so
+// that compacting them keeps the reports it needs. Compaction cannot see the
+// strategies given to a read alone: files that are compacted should be given
+// them through FileOptions.MergeStrategies or SetMergeStrategy instead.
+func WithMergeStrategy(key string, strategy MergeStrategy) CaptureOption {
+	return func(o *captureOptions) {
+		if o.strategies == nil {
+			o.strategies = mergeStrategies{}
+		}
+		o.strategies[key] = strategy
+	}
+}
+
+// newVersionReportMerger returns a merger resolving strategies from each of
+// overrides in turn, then from those registered with SetMergeStrategy.
+func newVersionReportMerger(overrides ...mergeStrategies) *versionReportMerger {
+	strategyMutex.RLock()
+	registered := make(mergeStrategies, len(registeredStrategies))
+	for key, strategy := range registeredStrategies {
+		registered[key] = strategy
+	}
+	strategyMutex.RUnlock()
+
+	lookups := append(append([]mergeStrategies(nil), overrides...), registered)
+	return &versionReportMerger{lookups: lookups}
+}
+
+// parseMergeStrategies parses MERGE_STRATEGIES_ENV_VAR. Malformed values are
+// ignored.
+func parseMergeStrategies(value string) map[string]MergeStrategy {
+	if len(value) == 0 {
+		return nil
+	}
+	var strategies map[string]MergeStrategy
+	if err := json.Unmarshal([]byte(value), &strategies); err != nil {
+		return nil
+	}
+	return strategies
+}
+
+// strategyFor returns the strategy for key.
+func (m *versionReportMerger) strategyFor(key string) MergeStrategy {
+	for _, strategies := range m.lookups {
+		if strategy, ok := strategies.lookup(key); ok {
+			return strategy
+		}
+	}
+	return MergeLastWins
+}
+
+// combine merges report into previous, the report kept so far for the same
+// key, according to strategy.
+func (m *versionReportMerger) combine(strategy MergeStrategy, previous, report VersionReport) VersionReport {
+	switch strategy {
+	case MergeFirstWins:
+		return previous
+	case MergeHighestPriority:
+		if report.Priority < previous.Priority {
+			return previous
+		}
+		return report
+	case MergeConcatenate:
+		report.PRReport = joinText(previous.PRReport, report.PRReport)
+		report.CommitReport = joinText(previous.CommitReport, report.CommitReport)
+		report.MustGenerate = report.MustGenerate || previous.MustGenerate
+		return report
+	case MergeErrorOnConflict:
+		if !samePayload(previous, report) {
+			m.conflicts = append(m.conflicts, &MergeConflictError{Key: report.Key, Kept: previous, Rejected: report})
+		}
+		return previous
+	default:
+		return report
+	}
+}
+
+func joinText(previous, next string) string {
+	switch {
+	case len(previous) == 0:
+		return next
+	case len(next) == 0:
+		return previous
+	}
+	return previous + "\n" + next
+}
+
+// samePayload reports whether a and b hold the same JSON fields.
+func samePayload(a, b VersionReport) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
@@ -0,0 +1,141 @@
+package versioning
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	reports := []VersionReport{
+		{Key: "sdk", Priority: 2, BumpType: BumpMinor, PRReport: "First", CommitReport: "first"},
+		{Key: "sdk", Priority: 3, BumpType: BumpPatch, PRReport: "Second", MustGenerate: true},
+		{Key: "sdk", Priority: 1, BumpType: BumpMajor, PRReport: "Third", CommitReport: "third"},
+	}
+
+	tests := []struct {
+		strategy MergeStrategy
+		expected VersionReport
+	}{
+		{strategy: MergeLastWins, expected: reports[2]},
+		{strategy: MergeFirstWins, expected: reports[0]},
+		{strategy: MergeHighestPriority, expected: reports[1]},
+		{strategy: MergeConcatenate, expected: VersionReport{
+			Key:          "sdk",
+			Priority:     1,
+			BumpType:     BumpMajor,
+			PRReport:     "First\nSecond\nThird",
+			CommitReport: "first\nthird",
+			MustGenerate: true,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			merged, err := mergeVersionReports(reports, mergeStrategies{"sdk": tt.strategy})
+			require.NoError(t, err)
+			require.Len(t, merged.Reports, 1)
+			merged.Reports[0].readIndex = 0
+			assert.Equal(t, tt.expected, merged.Reports[0])
+			assert.Equal(t, map[string]MergeStrategy{"sdk": tt.strategy}, merged.Strategies)
+		})
+	}
+}
+
+func TestMergeErrorOnConflict(t *testing.T) {
+	strategies := mergeStrategies{"sdk": MergeErrorOnConflict}
+
+	merged, err := mergeVersionReports([]VersionReport{
+		{Key: "sdk", PRReport: "Same"},
+		{Key: "sdk", PRReport: "Same"},
+	}, strategies)
+	require.NoError(t, err, "writing the same report twice is not a conflict")
+	require.Len(t, merged.Reports, 1)
+
+	merged, err = mergeVersionReports([]VersionReport{
+		{Key: "sdk", PRReport: "First"},
+		{Key: "other", PRReport: "Other"},
+		{Key: "sdk", PRReport: "Second"},
+	}, strategies)
+	assert.ErrorIs(t, err, ErrMergeConflict)
+	var conflict *MergeConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, "sdk", conflict.Key)
+	assert.Equal(t, "First", conflict.Kept.PRReport)
+	assert.Equal(t, "Second", conflict.Rejected.PRReport)
+
+	require.NotNil(t, merged, "the merged report is still returned")
+	require.Len(t, merged.Reports, 2)
+	assert.Equal(t, "First", merged.Reports[1].PRReport)
+}
+
+func TestMergeStrategyLookup(t *testing.T) {
+	strategies := mergeStrategies{
+		"sdk-*":          MergeFirstWins,
+		"sdk-go-*":       MergeConcatenate,
+		"sdk-go-special": MergeHighestPriority,
+	}
+
+	for key, expected := range map[string]MergeStrategy{
+		"sdk-typescript": MergeFirstWins,
+		"sdk-go-client":  MergeConcatenate,
+		"sdk-go-special": MergeHighestPriority,
+	} {
+		strategy, ok := strategies.lookup(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, expected, strategy, key)
+	}
+	_, ok := strategies.lookup("other")
+	assert.False(t, ok)
+}
+
+func TestSetMergeStrategy(t *testing.T) {
+	SetMergeStrategy("docs-*", MergeConcatenate)
+	defer SetMergeStrategy("docs-*", "")
+
+	recorder := NewRecorder(NewMemorySink())
+	ctx := WithRecorder(context.Background(), recorder)
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "docs-api", PRReport: "Part 1"}))
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "docs-api", PRReport: "Part 2"}))
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "sdk", PRReport: "Old"}))
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "sdk", PRReport: "New"}))
+
+	capture, err := ReadVersionReports(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "New\nPart 1\nPart 2\n", capture.V1.GetMarkdownSection())
+	assert.Equal(t, MergeConcatenate, capture.V1.Strategies["docs-api"])
+	assert.Equal(t, MergeLastWins, capture.V1.Strategies["sdk"])
+
+	// Capture options take precedence over registered strategies.
+	capture, err = ReadVersionReports(ctx, WithMergeStrategy("docs-api", MergeFirstWins), WithMergeStrategy("sdk", MergeErrorOnConflict))
+	assert.ErrorIs(t, err, ErrMergeConflict)
+	require.NotNil(t, capture)
+	assert.Equal(t, "Old\nPart 1\n", capture.V1.GetMarkdownSection())
+}
+
+func TestCaptureWithMergeStrategy(t *testing.T) {
+	recorder := NewRecorder(NewMemorySink())
+	capture, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "sdk", PRReport: "First"}))
+		return AddVersionReport(ctx, VersionReport{Key: "sdk", PRReport: "Second"})
+	}, WithMergeStrategy("sdk", MergeErrorOnConflict))
+
+	assert.ErrorIs(t, err, ErrMergeConflict)
+	require.NotNil(t, capture)
+	assert.Equal(t, "First\n", capture.V1.GetMarkdownSection())
+}
+
+func TestMergeStrategiesEnviron(t *testing.T) {
+	strategies := map[string]MergeStrategy{"sdk": MergeFirstWins, "docs-*": MergeConcatenate}
+	recorder := NewRecorder(&FileSink{Location: "version.json", FileOptions: FileOptions{MergeStrategies: strategies}})
+
+	for _, entry := range recorder.Environ() {
+		if name, value, _ := strings.Cut(entry, "="); name == MERGE_STRATEGIES_ENV_VAR {
+			assert.Equal(t, strategies, parseMergeStrategies(value))
+			return
+		}
+	}
+	t.Fatalf("%s is not exported", MERGE_STRATEGIES_ENV_VAR)
+}
@@ -0,0 +1,149 @@
+package versioning
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provenance describes the process that wrote a record. It is stored in the
+// record's envelope, next to the payload fields, so consumers parsing the
+// payload are not affected by it.
+type Provenance struct {
+	Pid        int    `json:"pid"`
+	Executable string `json:"executable,omitempty"`
+	Hostname   string `json:"hostname,omitempty"`
+	// Timestamp is when the record was reported. Unlike the time a record is
+	// written, it is kept when the record is forwarded to an enclosing
+	// capture.
+	Timestamp   time.Time `json:"timestamp"`
+	Tool        string    `json:"tool,omitempty"`
+	ToolVersion string    `json:"tool_version,omitempty"`
+	// Package is the import path of the package that reported the record, if
+	// enabled with SetRecordCallerPackage.
+	Package string `json:"package,omitempty"`
+}
+
+var (
+	provenanceMutex     sync.RWMutex
+	toolName            string
+	toolVersion         string
+	recordCallerPackage bool
+
+	processOnce       sync.Once
+	processExecutable string
+	processHostname   string
+)
+
+// SetTool sets the name and version of the tool stamped on every record
+// reported by this process.
+func SetTool(name, version string) {
+	provenanceMutex.Lock()
+	defer provenanceMutex.Unlock()
+	toolName, toolVersion = name, version
+}
+
+// SetRecordCallerPackage sets whether records are stamped with the package
+// that reported them. Finding it walks the stack on every report, so it is
+// off by default.
+func SetRecordCallerPackage(enabled bool) {
+	provenanceMutex.Lock()
+	defer provenanceMutex.Unlock()
+	recordCallerPackage = enabled
+}
+
+// newProvenance describes the current process and the caller reporting a
+// record.
+func newProvenance() *Provenance {
+	processOnce.Do(func() {
+		if executable, err := os.Executable(); err == nil {
+			processExecutable = filepath.Base(executable)
+		}
+		processHostname, _ = os.Hostname()
+	})
+
+	provenanceMutex.RLock()
+	p := &Provenance{
+		Pid:         os.Getpid(),
+		Executable:  processExecutable,
+		Hostname:    processHostname,
+		Timestamp:   time.Now().UTC(),
+		Tool:        toolName,
+		ToolVersion: toolVersion,
+	}
+	withCaller := recordCallerPackage
+	provenanceMutex.RUnlock()
+
+	if withCaller {
+		p.Package = callerPackage()
+	}
+	return p
+}
+
+var packagePath = reflect.TypeOf(Recorder{}).PkgPath()
+
+// callerPackage returns the import path of the first function on the stack
+// outside this package.
+func callerPackage() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if pkg := functionPackage(frame.Function); len(pkg) > 0 && pkg != packagePath {
+			return pkg
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// functionPackage returns the import path in a qualified function name such
+// as "example.com/pkg.(*T).Method".
+func functionPackage(function string) string {
+	lastSlash := strings.LastIndexByte(function, '/')
+	dot := strings.IndexByte(function[lastSlash+1:], '.')
+	if dot < 0 {
+		return ""
+	}
+	return function[:lastSlash+1+dot]
+}
+
+// Provenance returns the process that reported r, or nil if r was written by a
+// version of this package that did not record it.
+func (r VersionReport) Provenance() *Provenance {
+	return r.provenance
+}
+
+// Provenance returns the process that reported t, or nil if t was written by a
+// version of this package that did not record it.
+func (t VersionReportV2Target) Provenance() *Provenance {
+	return t.provenance
+}
+
+// Provenance returns the process that reported the merged report for key, or
+// nil if there is no such report or it carries no provenance.
+func (m *MergedVersionReport) Provenance(key string) *Provenance {
+	for _, report := range m.Reports {
+		if report.Key == key {
+			return report.provenance
+		}
+	}
+	return nil
+}
+
+// Provenance returns the process that reported the named target, using the
+// last report if the target was reported more than once. It returns nil if
+// there is no such target or it carries no provenance.
+func (d *VersionReportV2Data) Provenance(targetName string) *Provenance {
+	for i := len(d.Targets) - 1; i >= 0; i-- {
+		if d.Targets[i].TargetName == targetName {
+			return d.Targets[i].provenance
+		}
+	}
+	return nil
+}
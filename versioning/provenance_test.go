@@ -0,0 +1,97 @@
+package versioning
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvenance(t *testing.T) {
+	SetTool("speakeasy", "1.2.3")
+	SetRecordCallerPackage(true)
+	t.Cleanup(func() {
+		SetTool("", "")
+		SetRecordCallerPackage(false)
+	})
+
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	ctx := WithRecorder(context.Background(), NewRecorder(sink))
+
+	before := time.Now().UTC()
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "go", BumpType: BumpMinor}))
+	require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	check := func(t *testing.T, provenance *Provenance) {
+		require.NotNil(t, provenance)
+		assert.Equal(t, os.Getpid(), provenance.Pid)
+		assert.Equal(t, "versioning.test", provenance.Executable)
+		assert.Equal(t, hostname, provenance.Hostname)
+		assert.False(t, provenance.Timestamp.Before(before))
+		assert.Equal(t, "speakeasy", provenance.Tool)
+		assert.Equal(t, "1.2.3", provenance.ToolVersion)
+		// The tests live in this package, so the first caller outside it
+		// is the test runner.
+		assert.Equal(t, "testing", provenance.Package)
+	}
+
+	capture, err := NewRecorder(sink).Read(ctx)
+	require.NoError(t, err)
+	check(t, capture.V1.Provenance("go"))
+	check(t, capture.V1.Reports[0].Provenance())
+	check(t, capture.V2.Provenance("go"))
+	assert.Nil(t, capture.V1.Provenance("python"))
+
+	// The provenance is only stored in the envelope, so the payload is
+	// unchanged for existing consumers.
+	data, err := os.ReadFile(location)
+	require.NoError(t, err)
+	var fields map[string]json.RawMessage
+	line, _, _ := strings.Cut(string(data), "\n")
+	require.NoError(t, json.Unmarshal([]byte(line), &fields))
+	assert.NotContains(t, fields, "provenance")
+	assert.Contains(t, string(fields[envelopeKey]), `"provenance":{`)
+
+	payload, err := json.Marshal(capture.V1.Reports[0])
+	require.NoError(t, err)
+	assert.NotContains(t, string(payload), "provenance")
+}
+
+func TestProvenanceSurvivesForwarding(t *testing.T) {
+	recorder := NewRecorder(NewMemorySink())
+	var reported *Provenance
+	outer, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+		inner, err := RecorderFromContext(ctx).Capture(ctx, func(ctx context.Context) error {
+			return AddVersionReport(ctx, VersionReport{Key: "go"})
+		})
+		require.NoError(t, err)
+		reported = inner.V1.Provenance("go")
+		return err
+	})
+	require.NoError(t, err)
+	require.NotNil(t, reported)
+	assert.Equal(t, reported, outer.V1.Provenance("go"))
+	assert.Empty(t, reported.Package)
+}
+
+func TestFunctionPackage(t *testing.T) {
+	tests := map[string]string{
+		"main.main": "main",
+		"github.com/speakeasy-api/versioning-reports/versioning.(*Recorder).AddVersionReport": "github.com/speakeasy-api/versioning-reports/versioning",
+		"example.com/gen/pkg.Generate.func1":                                                  "example.com/gen/pkg",
+		"example.com/gen.v2/pkg.Map[...]":                                                     "example.com/gen.v2/pkg",
+		"":                                                                                    "",
+	}
+	for function, expected := range tests {
+		assert.Equal(t, expected, functionPackage(function), function)
+	}
+}
@@ -0,0 +1,514 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Recorder is an explicit destination for version reports. Unlike the
+// package-level functions, a Recorder created with NewRecorder never reads or
+// modifies the process environment, so several recorders can capture reports
+// concurrently in one process.
+type Recorder struct {
+	// sink is nil for the default recorder, which resolves its sink from
+	// SetReportSink and the environment on every call.
+	sink ReportSink
+	// destination holds the environment entries pointing subprocesses at
+	// sink, if they can reach it.
+	destination []string
+
+	// environ is set for the default recorder and the scopes of its captures,
+	// which point the process environment at each capture so that
+	// subprocesses inherit it.
+	environ bool
+}
+
+// defaultRecorder backs the package-level functions.
+var defaultRecorder = &Recorder{environ: true}
+
+// environKeys are the variables through which subprocesses find their
+// report destination.
+var environKeys = []string{
+	ENV_VAR_PREFIX, V2_LOCATION_ENV_VAR, DIR_ENV_VAR, SOCKET_ENV_VAR,
+	FRAMED_ENV_VAR, COMPACT_THRESHOLD_ENV_VAR, V2_COMPACTION_ENV_VAR, FILE_MODE_ENV_VAR, LIMITS_ENV_VAR,
+	MERGE_STRATEGIES_ENV_VAR,
+}
+
+// NewRecorder returns a Recorder writing to every given sink. Reads are served
+// by the first sink.
+func NewRecorder(sinks ...ReportSink) *Recorder {
+	r := &Recorder{}
+	if len(sinks) == 1 {
+		r.sink = sinks[0]
+	} else {
+		r.sink = NewMultiSink(sinks...)
+	}
+	for _, sink := range sinks {
+		if r.destination = destinationOf(sink); r.destination != nil {
+			break
+		}
+	}
+	return r
+}
+
+// destinationOf returns the environment entries that let subprocesses report
+// into sink, or nil if they cannot reach it.
+func destinationOf(sink ReportSink) []string {
+	switch sink := sink.(type) {
+	case *FileSink:
+		var env []string
+		if len(sink.Location) > 0 {
+			env = append(env, ENV_VAR_PREFIX+"="+sink.Location)
+		}
+		if len(sink.V2Location) > 0 {
+			env = append(env, V2_LOCATION_ENV_VAR+"="+sink.V2Location)
+		}
+		if len(env) > 0 {
+			env = append(env, sink.FileOptions.environ()...)
+		}
+		return env
+	case *DirSink:
+		return append([]string{DIR_ENV_VAR + "=" + sink.Dir}, sink.FileOptions.environ()...)
+	case *SocketSink:
+		return []string{SOCKET_ENV_VAR + "=" + sink.Address}
+	}
+	return nil
+}
+
+// NewFileRecorder returns a Recorder appending to the JSONL report files at
+// location, in the same format as ENV_VAR_PREFIX.
+func NewFileRecorder(location string) *Recorder {
+	return NewRecorder(NewFileSink(location))
+}
+
+// reportSink returns the sink to use, or nil if reporting is not configured.
+func (r *Recorder) reportSink() ReportSink {
+	if r.sink != nil {
+		return r.sink
+	}
+	return activeSink()
+}
+
+// Environ returns the environment variables that let a subprocess report into
+// this recorder, in the "key=value" form used by exec.Cmd.Env. It is empty for
+// recorders that subprocesses cannot reach, such as in-memory ones.
+func (r *Recorder) Environ() []string {
+	if r.sink == nil {
+		sinkMutex.RLock()
+		sink := configuredSink
+		sinkMutex.RUnlock()
+		if sink != nil {
+			return destinationOf(sink)
+		}
+
+		var env []string
+		for _, key := range environKeys {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+		return env
+	}
+
+	return r.destination
+}
+
+// reachable reports whether subprocesses can report into r.
+func (r *Recorder) reachable() bool {
+	if r.sink == nil {
+		sinkMutex.RLock()
+		defer sinkMutex.RUnlock()
+		return configuredSink == nil || len(destinationOf(configuredSink)) > 0
+	}
+	return len(r.Environ()) > 0
+}
+
+// These track the captures of the default recorder that point the
+// process environment at themselves, so that captures overlapping without
+// being nested do not restore each other's values.
+var (
+	environMutex sync.Mutex
+	// exported holds the values exported by each active capture, oldest
+	// first. The environment always reflects the newest one.
+	exported []*environExport
+	// baseline holds the values from before the first active capture, nil
+	// for variables that were not set.
+	baseline map[string]*string
+)
+
+// environExport is the environment exported by a single capture.
+type environExport struct {
+	values map[string]string
+}
+
+// currentExport returns the export the environment reflects, or nil if no
+// capture is active.
+func currentExport() *environExport {
+	environMutex.Lock()
+	defer environMutex.Unlock()
+	if len(exported) == 0 {
+		return nil
+	}
+	return exported[len(exported)-1]
+}
+
+// isExported reports whether the capture that made export is still active.
+func isExported(export *environExport) bool {
+	environMutex.Lock()
+	defer environMutex.Unlock()
+	for _, e := range exported {
+		if e == export {
+			return true
+		}
+	}
+	return false
+}
+
+// exportEnviron points the process environment at env, unsetting any
+// destination variable it does not mention, and returns a function that
+// undoes it. Undoing points the environment back at the newest capture still
+// active, or restores the values from before the first one, so captures may
+// end in any order. While captures overlap, the environment, and so any
+// subprocess started, points at the newest one.
+func exportEnviron(env []string) func() {
+	values := make(map[string]string)
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		values[key] = value
+	}
+
+	environMutex.Lock()
+	defer environMutex.Unlock()
+	if len(exported) == 0 {
+		baseline = make(map[string]*string, len(environKeys))
+		for _, key := range environKeys {
+			if value, ok := os.LookupEnv(key); ok {
+				baseline[key] = &value
+			}
+		}
+	}
+	export := &environExport{values: values}
+	exported = append(exported, export)
+	applyEnviron(values)
+
+	return func() {
+		environMutex.Lock()
+		defer environMutex.Unlock()
+		for i := range exported {
+			if exported[i] == export {
+				exported = append(exported[:i], exported[i+1:]...)
+				break
+			}
+		}
+		if len(exported) > 0 {
+			applyEnviron(exported[len(exported)-1].values)
+			return
+		}
+		for _, key := range environKeys {
+			if value := baseline[key]; value != nil {
+				os.Setenv(key, *value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+		baseline = nil
+	}
+}
+
+// applyEnviron sets the destination variables in values and unsets the others.
+func applyEnviron(values map[string]string) {
+	for _, key := range environKeys {
+		if value, ok := values[key]; ok {
+			os.Setenv(key, value)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// AddVersionReport records a V1 report. It is a no-op if reporting is not configured.
+func (r *Recorder) AddVersionReport(ctx context.Context, report VersionReport) error {
+	sink := r.reportSink()
+	if sink == nil {
+		return nil
+	}
+	if report.BumpType == "" {
+		report.BumpType = BumpNone
+	}
+	if report.command == nil {
+		report.command = commandFromEnv()
+	}
+	if report.provenance == nil {
+		report.provenance = newProvenance()
+	}
+	return sink.WriteVersionReport(ctx, report)
+}
+
+// AddVersionReportV2Target records a single target's changelog data. It is a
+// no-op if reporting is not configured.
+func (r *Recorder) AddVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
+	sink := r.reportSink()
+	if sink == nil {
+		// Reporting not configured, silently skip (backwards compatible)
+		return nil
+	}
+	if target.command == nil {
+		target.command = commandFromEnv()
+	}
+	if target.provenance == nil {
+		target.provenance = newProvenance()
+	}
+	return sink.WriteVersionReportV2Target(ctx, target)
+}
+
+// MustGenerate reports whether any recorded V1 report requires generation.
+// Conflicting keys do not prevent the merged reports from being checked.
+func (r *Recorder) MustGenerate(ctx context.Context) bool {
+	report, err := readMergedVersionReport(ctx, r.reportSink(), nil)
+	if (err != nil && !errors.Is(err, ErrMergeConflict)) || report == nil {
+		return false
+	}
+	return report.MustGenerate()
+}
+
+// Capture runs f and returns the V1 and V2 reports written inside it: through
+// the context passed to f, or by subprocesses using that context's
+// RecorderFromContext(ctx).Environ(). Once f has finished, the captured
+// reports are also forwarded to r, so nested captures each see their own
+// reports while the outermost capture still sees everything.
+//
+// If f fails, or the reports cannot all be read back, the reports gathered so
+// far are still returned and forwarded, together with the errors joined by
+// errors.Join.
+//
+// However f ends, by returning, failing or panicking, the process environment
+// is restored exactly as it was, including whether each variable was set at
+// all, and the capture's temporary files are removed. If f panics, the
+// reports gathered so far are still forwarded to r first, and the panic
+// resumes once all that is done.
+func (r *Recorder) Capture(ctx context.Context, f func(ctx context.Context) error, opts ...CaptureOption) (*VersionReportCapture, error) {
+	var options captureOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	parent := r.reportSink()
+	// The default recorder finds its parent through the environment, which
+	// may have been exported by a capture that only overlaps this one and
+	// ends first. Reports are then not forwarded to its removed files.
+	var parentExport *environExport
+	if r.sink == nil {
+		parentExport = currentExport()
+	}
+	scope, cleanup, err := r.newScope(options)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default recorder also points the environment at the scope so that
+	// subprocesses inherit it without any extra wiring.
+	restore := func() {}
+	if r.environ {
+		restore = exportEnviron(scope.Environ())
+	}
+
+	// gather reads the reports back and forwards them to the parent. Whatever
+	// was reported is kept even if f or reading it back failed, as it is what
+	// explains the failure.
+	gathered := false
+	gather := func() (*VersionReportCapture, error) {
+		gathered = true
+		forward := &forwarder{ctx: ctx}
+		if parent != nil && (parentExport == nil || isExported(parentExport)) {
+			forward.sink = parent
+		}
+		capture, err := readVersionReportCapture(ctx, scope.sink, options, forward)
+		if parentExport != nil && !isExported(parentExport) {
+			forward.err = nil // the parent ended while forwarding
+		}
+		return capture, joinErrors(err, forward.err)
+	}
+	defer func() {
+		// The files are removed even if restoring the environment panics, and
+		// only after it, so nothing in this process is left pointing at them.
+		defer cleanup()
+		defer restore()
+		if !gathered {
+			// f panicked, but what it reported still reaches the parent
+			// before the scope is removed and the panic resumes.
+			gather()
+		}
+	}()
+
+	fErr := f(WithRecorder(ctx, scope))
+	capture, err := gather()
+	return capture, joinErrors(fErr, err)
+}
+
+// Read returns every report recorded so far. Only WithLenientDecoding,
+// WithMergeStrategy, WithStrictConflicts and WithHistory apply to reads; other
+// options are ignored. If reading fails, the reports that could be read are
+// returned along with the error.
+func (r *Recorder) Read(ctx context.Context, opts ...CaptureOption) (*VersionReportCapture, error) {
+	var options captureOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	sink := r.reportSink()
+	if sink == nil {
+		return nil, ErrLocationNotSet
+	}
+
+	return readVersionReportCapture(ctx, sink, options, &forwarder{ctx: ctx})
+}
+
+// newScope returns a recorder collecting the reports written during a single
+// capture, along with a function that releases its resources. Scopes are kept
+// in memory unless subprocesses need to reach them.
+func (r *Recorder) newScope(options captureOptions) (*Recorder, func(), error) {
+	parent := r.reportSink()
+	limits, err := scopeLimits(parent, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if options.socketCollector {
+		scope := &Recorder{sink: limitMemorySink(NewMemorySink(), limits), environ: r.environ}
+		collector, err := StartCollector(scope.sink)
+		if err != nil {
+			return nil, nil, err
+		}
+		scope.destination = destinationOf(NewSocketSink(collector.Address()))
+		return scope, func() { collector.Close() }, nil
+	}
+
+	if !r.reachable() {
+		return &Recorder{sink: limitMemorySink(NewMemorySink(), limits), environ: r.environ}, func() {}, nil
+	}
+
+	// The files live in a directory only we can enter, so that other users
+	// on the machine can neither read them nor swap them for symlinks.
+	dir, err := os.MkdirTemp("", "version-report")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	sink := NewFileSink(filepath.Join(dir, "version.json"))
+	sink.FileOptions = fileOptionsOf(parent)
+	if parent == nil {
+		// A top-level capture still honours the options it was started with,
+		// and passes them on to its subprocesses.
+		sink.FileOptions = fileOptionsFromEnv()
+	}
+	sink.Limits = limits
+	if len(options.strategies) > 0 {
+		// Compactions, including those made by subprocesses, have to keep
+		// what the capture's strategies need to merge its reports.
+		strategies := make(map[string]MergeStrategy, len(sink.MergeStrategies)+len(options.strategies))
+		for key, strategy := range sink.MergeStrategies {
+			strategies[key] = strategy
+		}
+		for key, strategy := range options.strategies {
+			strategies[key] = strategy
+		}
+		sink.MergeStrategies = strategies
+	}
+	if options.history {
+		// Nothing the history needs is compacted away, in this process or in
+		// subprocesses, which no longer compact automatically.
+		sink.keepHistory = true
+	}
+	if sink.keepHistory {
+		sink.CompactThreshold = 0
+	}
+	sink.Framed = sink.Framed || options.framed
+	if options.fileMode != 0 {
+		sink.FileMode = options.fileMode
+	} else if sink.FileMode == 0 {
+		sink.FileMode = 0600
+	}
+
+	tempFile, err := os.OpenFile(sink.Location, os.O_CREATE|os.O_EXCL|os.O_WRONLY, sink.fileMode())
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	tempFile.Close()
+
+	scope := NewRecorder(sink)
+	scope.environ = r.environ
+	return scope, cleanup, nil
+}
+
+// forwarder writes the reports read back from a capture to its parent sink,
+// in their original order. It does nothing without a sink, and stops at the
+// first error.
+type forwarder struct {
+	ctx  context.Context
+	sink ReportSink
+	err  error
+}
+
+func (f *forwarder) writeVersionReport(report VersionReport) {
+	if f.sink != nil && f.err == nil {
+		f.err = f.sink.WriteVersionReport(f.ctx, report)
+	}
+}
+
+func (f *forwarder) writeVersionReportV2Target(target VersionReportV2Target) {
+	if f.sink != nil && f.err == nil {
+		f.err = f.sink.WriteVersionReportV2Target(f.ctx, target)
+	}
+}
+
+// scopeLimits returns the Limits of a capture within parent: those it was
+// started with, or else those of parent, or of LIMITS_ENV_VAR for a top-level
+// capture. Spilled records outlive the capture in the parent's directory; a
+// capture with nowhere else to spill them fails to start.
+func scopeLimits(parent ReportSink, options captureOptions) (Limits, error) {
+	limits := fileOptionsOf(parent).Limits
+	if parent == nil {
+		limits = parseLimits(os.Getenv(LIMITS_ENV_VAR))
+	}
+	if options.limits != nil {
+		limits = *options.limits
+	}
+	if len(limits.SpillDir) == 0 {
+		limits.SpillDir = spillDirOf(parent)
+	}
+	if limits.spills() && len(limits.SpillDir) == 0 {
+		return limits, fmt.Errorf("%s limits need a SpillDir outside the capture", OverflowSpill)
+	}
+	return limits, nil
+}
+
+// spillDirOf returns the directory where records spilled by sink end up, or
+// "" if sink does not write files.
+func spillDirOf(sink ReportSink) string {
+	switch sink := sink.(type) {
+	case *FileSink:
+		switch {
+		case len(sink.Limits.SpillDir) > 0:
+			return sink.Limits.SpillDir
+		case len(sink.Location) > 0:
+			return filepath.Dir(sink.Location)
+		case len(sink.V2Location) > 0:
+			return filepath.Dir(sink.V2Location)
+		}
+	case *DirSink:
+		if len(sink.Limits.SpillDir) > 0 {
+			return sink.Limits.SpillDir
+		}
+		return sink.Dir
+	case *limitedSink:
+		return sink.limits.Limits.SpillDir
+	}
+	return ""
+}
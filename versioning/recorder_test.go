@@ -0,0 +1,120 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderConcurrentCaptures(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	for i := 0; i < 4; i++ {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+
+			recorder := NewRecorder(NewMemorySink())
+			if i%2 == 0 {
+				recorder = NewFileRecorder(filepath.Join(t.TempDir(), "version.json"))
+			}
+
+			key := fmt.Sprintf("recorder%d", i)
+			capture, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+				if err := AddVersionReport(ctx, VersionReport{Key: key, MustGenerate: true}); err != nil {
+					return err
+				}
+				return AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: key})
+			})
+
+			require.NoError(t, err)
+			require.NotNil(t, capture)
+			require.Len(t, capture.V1.Reports, 1)
+			assert.Equal(t, key, capture.V1.Reports[0].Key)
+			assert.Equal(t, BumpNone, capture.V1.Reports[0].BumpType)
+			require.NotNil(t, capture.V2)
+			require.Len(t, capture.V2.Targets, 1)
+			assert.Equal(t, key, capture.V2.Targets[0].TargetName)
+			assert.True(t, recorder.MustGenerate(context.Background()))
+			assert.Empty(t, os.Getenv(ENV_VAR_PREFIX))
+		})
+	}
+}
+
+func TestRecorderEnviron(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	assert.Equal(t, []string{
+		ENV_VAR_PREFIX + "=" + location,
+		V2_LOCATION_ENV_VAR + "=" + v2LocationFor(location),
+	}, NewFileRecorder(location).Environ())
+	assert.Equal(t, []string{DIR_ENV_VAR + "=reports"}, NewRecorder(NewDirSink("reports")).Environ())
+	assert.Empty(t, NewRecorder(NewMemorySink()).Environ())
+}
+
+func TestRecorderWithSubprocesses(t *testing.T) {
+	for name, opts := range map[string][]CaptureOption{
+		"file":   nil,
+		"socket": {WithSocketCollector()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			recorder := NewFileRecorder(filepath.Join(t.TempDir(), "version.json"))
+			capture, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+				cmd := exec.Command("go", "run", "testdata/subprocess.go", "1", "from recorder")
+				cmd.Env = append(os.Environ(), RecorderFromContext(ctx).Environ()...)
+				return cmd.Run()
+			}, opts...)
+
+			require.NoError(t, err)
+			require.Len(t, capture.V1.Reports, 1)
+			assert.Equal(t, "subprocess1", capture.V1.Reports[0].Key)
+			assert.Equal(t, "from recorder", capture.V1.Reports[0].PRReport)
+			assert.True(t, recorder.MustGenerate(context.Background()))
+			_, wasSet := os.LookupEnv(SOCKET_ENV_VAR)
+			assert.False(t, wasSet)
+		})
+	}
+}
+
+func TestDefaultRecorderUnconfigured(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	ctx := context.Background()
+	assert.NoError(t, AddVersionReport(ctx, VersionReport{Key: "test"}))
+	assert.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+	assert.False(t, MustGenerate(ctx))
+}
+
+func TestRecorderNestedCapture(t *testing.T) {
+	memory := NewMemorySink()
+	recorder := NewRecorder(memory)
+
+	var inner *VersionReportCapture
+	outer, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+		if err := AddVersionReport(ctx, VersionReport{Key: "outer"}); err != nil {
+			return err
+		}
+
+		var err error
+		inner, err = RecorderFromContext(ctx).Capture(ctx, func(ctx context.Context) error {
+			return AddVersionReport(ctx, VersionReport{Key: "inner"})
+		})
+		return err
+	})
+
+	require.NoError(t, err)
+	require.Len(t, inner.V1.Reports, 1)
+	assert.Equal(t, "inner", inner.V1.Reports[0].Key)
+	require.Len(t, outer.V1.Reports, 2)
+
+	// Reports reach the recorder itself once the outermost capture finishes.
+	reports, err := memory.ReadVersionReports(context.Background())
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Equal(t, "outer", reports[0].Key)
+	assert.Equal(t, "inner", reports[1].Key)
+}
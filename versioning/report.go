@@ -3,10 +3,8 @@
 package versioning
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"os"
 	"sort"
 	"sync"
@@ -26,6 +24,8 @@ const (
 
 type VersionReport struct {
 	readIndex    int
+	command      []string
+	provenance   *Provenance
 	Key          string   `json:"key"`
 	Priority     int      `json:"priority"`
 	BumpType     BumpType `json:"bump_type"`
@@ -33,6 +33,13 @@ type VersionReport struct {
 	MustGenerate bool     `json:"must_generate"`
 	PRReport     string   `json:"pr_report"`
 	CommitReport string   `json:"commit_report"`
+
+	// Overflow is set when the report was shrunk to fit the write Limits.
+	Overflow *ReportOverflow `json:"overflow,omitempty"`
+
+	// Extensions holds fields written by newer versions of this package, so
+	// that they survive being read and written back by this one.
+	Extensions map[string]json.RawMessage `json:"-"`
 }
 
 // VersionReportV2Data is the top-level container for V2 changelog data.
@@ -45,12 +52,22 @@ type VersionReportV2Data struct {
 
 // VersionReportV2Target represents the changes made to a single SDK target.
 type VersionReportV2Target struct {
+	command    []string
+	provenance *Provenance
+
 	TargetName      string                     `json:"target_name"`                // e.g., "typescript", "go", "python"
 	PackageName     string                     `json:"package_name,omitempty"`     // e.g., "@vercel/sdk", "github.com/vercel/sdk-go"
 	PreviousVersion string                     `json:"previous_version,omitempty"` // e.g., "1.23.7"
 	NewVersion      string                     `json:"new_version"`                // e.g., "1.23.8"
 	GeneratedAt     string                     `json:"generated_at,omitempty"`     // ISO8601 timestamp
 	Operations      []VersionReportV2Operation `json:"operations"`                 // List of changed operations
+
+	// Overflow is set when the target was shrunk to fit the write Limits.
+	Overflow *ReportOverflow `json:"overflow,omitempty"`
+
+	// Extensions holds fields written by newer versions of this package, so
+	// that they survive being read and written back by this one.
+	Extensions map[string]json.RawMessage `json:"-"`
 }
 
 // VersionReportV2OperationType indicates what kind of change happened to an operation.
@@ -89,44 +106,70 @@ type VersionReportV2FieldChange struct {
 
 const ENV_VAR_PREFIX = "SPEAKEASY_VERSION_REPORT_LOCATION"
 
-var fileMutex sync.Mutex
+// V2_LOCATION_ENV_VAR sets the V2 report file independently of the V1 one.
+// When it is not set, the V2 location is derived from ENV_VAR_PREFIX.
+const V2_LOCATION_ENV_VAR = "SPEAKEASY_VERSION_REPORT_V2_LOCATION"
 
-func loadFileForWriting() (*os.File, error) {
-	location := os.Getenv(ENV_VAR_PREFIX)
-	if len(location) == 0 {
-		return nil, fmt.Errorf("%s is not set", ENV_VAR_PREFIX)
-	}
-	return os.OpenFile(location, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// DIR_ENV_VAR switches reporting to directory mode: every process appends to
+// its own shard files in the directory, and reads merge all of them. It takes
+// precedence over ENV_VAR_PREFIX and V2_LOCATION_ENV_VAR.
+const DIR_ENV_VAR = "SPEAKEASY_VERSION_REPORT_DIR"
+
+var (
+	sinkMutex      sync.RWMutex
+	configuredSink ReportSink
+)
+
+// SetReportSink configures the sink used by AddVersionReport,
+// AddVersionReportV2Target and WithVersionReportCapture. Passing nil restores
+// the default, which appends to the file named by ENV_VAR_PREFIX.
+func SetReportSink(sink ReportSink) {
+	sinkMutex.Lock()
+	defer sinkMutex.Unlock()
+	configuredSink = sink
 }
 
-func AddVersionReport(ctx context.Context, report VersionReport) error {
-	if len(os.Getenv(ENV_VAR_PREFIX)) > 0 {
-		fileMutex.Lock()
-		defer fileMutex.Unlock()
+// activeSink returns the configured sink, falling back to the collector
+// advertised in SOCKET_ENV_VAR, the directory in DIR_ENV_VAR, and then to a
+// FileSink for the locations in ENV_VAR_PREFIX and V2_LOCATION_ENV_VAR.
+// Returns nil if reporting is not configured.
+func activeSink() ReportSink {
+	sinkMutex.RLock()
+	sink := configuredSink
+	sinkMutex.RUnlock()
+	if sink != nil {
+		return sink
+	}
 
-		f, err := loadFileForWriting()
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		if report.BumpType == "" {
-			report.BumpType = BumpNone
-		}
+	if address := os.Getenv(SOCKET_ENV_VAR); len(address) > 0 {
+		return NewSocketSink(address)
+	}
 
-		bytes, err := json.Marshal(report)
-		if err != nil {
-			return err
-		}
+	if dir := os.Getenv(DIR_ENV_VAR); len(dir) > 0 {
+		return &DirSink{Dir: dir, FileOptions: fileOptionsFromEnv()}
+	}
 
-		if _, err := f.Write(append(bytes, '\n')); err != nil {
-			return err
-		}
+	location := os.Getenv(ENV_VAR_PREFIX)
+	v2Location := getV2Location()
+	if len(location) == 0 && len(v2Location) == 0 {
+		return nil
 	}
-	return nil
+	return &FileSink{Location: location, V2Location: v2Location, FileOptions: fileOptionsFromEnv()}
+}
+
+// AddVersionReport records report with the recorder attached to ctx, falling
+// back to the location in ENV_VAR_PREFIX.
+func AddVersionReport(ctx context.Context, report VersionReport) error {
+	return RecorderFromContext(ctx).AddVersionReport(ctx, report)
 }
 
 type MergedVersionReport struct {
 	Reports []VersionReport
+	// Strategies records the MergeStrategy used for the key of each report.
+	Strategies map[string]MergeStrategy
+
+	conflicts []VersionConflict
+	history   map[string][]VersionReport
 }
 
 func (m *MergedVersionReport) MustGenerate() bool {
@@ -160,37 +203,83 @@ func (m *MergedVersionReport) GetCommitMarkdownSection() string {
 }
 
 func getMergedVersionReport() (*MergedVersionReport, error) {
-	location := os.Getenv(ENV_VAR_PREFIX)
-	if len(location) == 0 {
-		return nil, fmt.Errorf("%s is not set", ENV_VAR_PREFIX)
-	}
+	return readMergedVersionReport(context.Background(), activeSink(), nil)
+}
 
-	fileMutex.Lock()
-	defer fileMutex.Unlock()
+// readMergedVersionReport merges the V1 reports in sink as they are streamed,
+// so only the report kept so far for each key is ever held in memory. The
+// history of each key is not kept.
+func readMergedVersionReport(ctx context.Context, sink ReportSink, strategies mergeStrategies) (*MergedVersionReport, error) {
+	if sink == nil {
+		return nil, ErrLocationNotSet
+	}
 
-	// Read the entire file contents
-	contents, err := os.ReadFile(location)
+	merger := newVersionReportMerger(strategies, fileOptionsOf(sink).MergeStrategies)
+	var err error
+	streamVersionReports(ctx, sink)(func(report VersionReport, e error) bool {
+		if e != nil {
+			err = e
+			return false
+		}
+		merger.add(report)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
+	return merger.merged()
+}
+
+// mergeVersionReports merges reports, given in the order they were written,
+// by key according to the merge strategies, keeping the history of each key.
+// The merged report is returned even if some keys conflict.
+func mergeVersionReports(reports []VersionReport, strategies ...mergeStrategies) (*MergedVersionReport, error) {
+	merger := newVersionReportMerger(strategies...)
+	merger.history = make(map[string][]VersionReport)
+	for _, report := range reports {
+		merger.add(report)
+	}
+	return merger.merged()
+}
 
-	decoder := json.NewDecoder(bytes.NewReader(contents))
-	reports := make(map[string]VersionReport)
+// versionReportMerger builds a MergedVersionReport incrementally from reports
+// in the order they were written.
+type versionReportMerger struct {
+	lookups    []mergeStrategies
+	latest     map[string]VersionReport
+	strategies map[string]MergeStrategy
+	conflicts  []error
+	values     conflictTracker
+	// history, if not nil, collects every report by key.
+	history map[string][]VersionReport
+	count   int
+}
 
-	// While there are JSON objects to decode
+func (m *versionReportMerger) add(report VersionReport) {
+	if m.latest == nil {
+		m.latest = make(map[string]VersionReport)
+		m.strategies = make(map[string]MergeStrategy)
+	}
+	report.readIndex = m.count
+	m.count++
+	m.values.addVersionReport(report)
+	if m.history != nil {
+		m.history[report.Key] = append(m.history[report.Key], report)
+	}
 
-	for i := 0; decoder.More(); i++ {
-		var report VersionReport
-		if err := decoder.Decode(&report); err != nil {
-			return nil, err
-		}
-		report.readIndex = i
-		reports[report.Key] = report
+	previous, ok := m.latest[report.Key]
+	if !ok {
+		m.latest[report.Key] = report
+		m.strategies[report.Key] = m.strategyFor(report.Key)
+		return
 	}
+	m.latest[report.Key] = m.combine(m.strategies[report.Key], previous, report)
+}
 
+func (m *versionReportMerger) merged() (*MergedVersionReport, error) {
 	// Create a slice of the latest reports
-	orderedReports := make([]VersionReport, 0, len(reports))
-	for _, report := range reports {
+	orderedReports := make([]VersionReport, 0, len(m.latest))
+	for _, report := range m.latest {
 		orderedReports = append(orderedReports, report)
 	}
 
@@ -203,77 +292,154 @@ func getMergedVersionReport() (*MergedVersionReport, error) {
 		return orderedReports[i].Priority > orderedReports[j].Priority
 	})
 
-	return &MergedVersionReport{Reports: orderedReports}, nil
+	merged := &MergedVersionReport{
+		Reports:    orderedReports,
+		Strategies: m.strategies,
+		conflicts:  m.values.conflicts(func(c *VersionConflict, name string) { c.Key = name }),
+		history:    m.history,
+	}
+	return merged, joinErrors(m.conflicts...)
 }
 
 // VersionReportCapture holds both V1 and V2 version reports.
 type VersionReportCapture struct {
 	V1 *MergedVersionReport
 	V2 *VersionReportV2Data
+	// Diagnostics lists the records skipped by a lenient read.
+	Diagnostics []ReadDiagnostic
 }
 
-func WithVersionReportCapture[T any](ctx context.Context, f func(ctx context.Context) (T, error)) (*VersionReportCapture, T, error) {
-	var tempFile *os.File
-	var err error
-	var result T
-
-	if len(os.Getenv(ENV_VAR_PREFIX)) == 0 {
-		tempFile, err = os.CreateTemp("", "version.buf.json")
-		if err != nil {
-			return nil, result, err
-		}
-		defer os.Remove(tempFile.Name())
-		os.Setenv(ENV_VAR_PREFIX, tempFile.Name())
-
-		// Also clean up the V2 file that will be created
-		v2Location := getV2Location()
-		if len(v2Location) > 0 {
-			defer os.Remove(v2Location)
-		}
+// readVersionReportCapture reads every report in sink back, passing each of
+// them on to forward as it is read. V1 reports are merged as they are read,
+// with the strategies of options, then those of the FileOptions of sink, so
+// only the merged view and, with WithHistory, the history are kept in memory.
+// The capture is returned even if reading fails.
+func readVersionReportCapture(ctx context.Context, sink ReportSink, options captureOptions, forward *forwarder) (*VersionReportCapture, error) {
+	merger := newVersionReportMerger(options.strategies, fileOptionsOf(sink).MergeStrategies)
+	if options.history {
+		merger.history = make(map[string][]VersionReport)
+	}
+	targets, diagnostics, readErr := readAll(ctx, sink, options.lenient, func(report VersionReport) {
+		forward.writeVersionReport(report)
+		merger.add(report)
+	})
+	for _, target := range targets {
+		forward.writeVersionReportV2Target(target)
 	}
 
-	result, err = f(ctx)
-	if err != nil {
-		return nil, result, err
+	merged, err := merger.merged()
+	capture := &VersionReportCapture{
+		V1:          merged,
+		V2:          newVersionReportV2Data(targets),
+		Diagnostics: diagnostics,
+	}
+	if conflicts := capture.Conflicts(); options.strict && len(conflicts) > 0 {
+		err = joinErrors(err, &VersionConflictError{Conflicts: conflicts})
 	}
+	return capture, joinErrors(readErr, err)
+}
 
-	report, err := getMergedVersionReport()
-	reportV2, errV2 := GetVersionReportV2()
+// CaptureOption configures WithVersionReportCapture and Recorder.Capture.
+type CaptureOption func(*captureOptions)
+
+type captureOptions struct {
+	socketCollector bool
+	lenient         bool
+	framed          bool
+	fileMode        os.FileMode
+	strategies      mergeStrategies
+	strict          bool
+	limits          *Limits
+	history         bool
+}
 
-	if tempFile != nil {
-		os.Unsetenv(ENV_VAR_PREFIX)
+// WithSocketCollector makes the capture start a Collector and advertise it to
+// subprocesses through SOCKET_ENV_VAR (or RecorderFromContext(ctx).Environ()),
+// so that they send their reports over a Unix domain socket instead of
+// appending to a shared file.
+func WithSocketCollector() CaptureOption {
+	return func(o *captureOptions) {
+		o.socketCollector = true
 	}
+}
 
-	// Prioritize the function error, then V1 report error, then V2 report error
-	if err != nil {
-		return nil, result, err
+// WithLenientDecoding skips stored records that fail to decode, for example
+// lines left truncated by a crashed subprocess, instead of failing the whole
+// read. The skipped records are listed in VersionReportCapture.Diagnostics.
+// Without it, reads stop at the first undecodable record.
+func WithLenientDecoding() CaptureOption {
+	return func(o *captureOptions) {
+		o.lenient = true
+	}
+}
+
+// WithFramedRecords makes the capture, and the subprocesses reporting into it,
+// write records in the framed format (see FRAMED_ENV_VAR), so that torn writes
+// are reported as corrupt frames rather than merged by accident.
+func WithFramedRecords() CaptureOption {
+	return func(o *captureOptions) {
+		o.framed = true
 	}
-	if errV2 != nil {
-		return nil, result, errV2
+}
+
+// WithFileMode sets the permission of the files holding the capture's reports,
+// which are only readable by the current user (0600) by default.
+func WithFileMode(mode os.FileMode) CaptureOption {
+	return func(o *captureOptions) {
+		o.fileMode = mode.Perm()
 	}
+}
 
-	return &VersionReportCapture{V1: report, V2: reportV2}, result, nil
+// ReadVersionReports returns every report recorded so far with the recorder
+// attached to ctx, or in the file at ENV_VAR_PREFIX. Only WithLenientDecoding,
+// WithMergeStrategy, WithStrictConflicts and WithHistory apply to reads; other
+// options are ignored.
+func ReadVersionReports(ctx context.Context, opts ...CaptureOption) (*VersionReportCapture, error) {
+	return RecorderFromContext(ctx).Read(ctx, opts...)
+}
+
+// WithVersionReportCapture runs f and returns every report written inside it,
+// then forwards them to the enclosing capture, if any. If ctx carries a
+// recorder the capture is delegated to it. Otherwise, unless a sink has been
+// configured, reports are captured into a temporary file advertised to
+// subprocesses through ENV_VAR_PREFIX. The environment and the temporary files
+// are cleaned up even if f fails or panics. Such captures may overlap without
+// being nested, as long as reports are made through the context passed to f:
+// the environment always points at the newest active capture, and is restored
+// once the last one ends.
+func WithVersionReportCapture[T any](ctx context.Context, f func(ctx context.Context) (T, error), opts ...CaptureOption) (*VersionReportCapture, T, error) {
+	var result T
+	capture, err := RecorderFromContext(ctx).Capture(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = f(ctx)
+		return err
+	}, opts...)
+	return capture, result, err
 }
 
+// MustGenerate reports whether any V1 report recorded with the recorder
+// attached to ctx, or in the file at ENV_VAR_PREFIX, requires generation.
 func MustGenerate(ctx context.Context) bool {
-	report, err := getMergedVersionReport()
-	if err != nil || report == nil {
-		return false
-	}
-	return report.MustGenerate()
+	return RecorderFromContext(ctx).MustGenerate(ctx)
 }
 
 // V2 Report Functions
 // These functions provide structured changelog storage and rendering,
 // running alongside V1 for backwards compatibility.
 
-var v2FileMutex sync.Mutex
-
-// getV2Location derives the V2 report file location from the V1 location.
+// getV2Location returns the V2 report file location from V2_LOCATION_ENV_VAR,
+// or else derives it from the V1 location.
 // If the V1 location is "/path/to/version.json", the V2 location will be "/path/to/version.v2.json".
-// Returns empty string if the V1 environment variable is not set.
+// Returns empty string if neither environment variable is set.
 func getV2Location() string {
-	v1Location := os.Getenv(ENV_VAR_PREFIX)
+	if location := os.Getenv(V2_LOCATION_ENV_VAR); len(location) > 0 {
+		return location
+	}
+	return v2LocationFor(os.Getenv(ENV_VAR_PREFIX))
+}
+
+// v2LocationFor derives the V2 report file location from a V1 location.
+func v2LocationFor(v1Location string) string {
 	if len(v1Location) == 0 {
 		return ""
 	}
@@ -287,75 +453,39 @@ func getV2Location() string {
 	return v1Location + ".v2"
 }
 
-// AddVersionReportV2Target appends a single target's changelog data to the V2 report file.
-// Multiple calls with different targets will accumulate in the same file.
-// Returns nil if the V1 environment variable is not set (graceful degradation).
+// AddVersionReportV2Target appends a single target's changelog data to the V2 report.
+// Multiple calls with different targets will accumulate in the same report.
+// The recorder attached to ctx is used first. Returns nil if no sink is
+// configured and no report location is set (graceful degradation).
 func AddVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
-	location := getV2Location()
-	if len(location) == 0 {
-		// V1 not configured, silently skip (backwards compatible)
-		return nil
-	}
-
-	v2FileMutex.Lock()
-	defer v2FileMutex.Unlock()
-
-	f, err := os.OpenFile(location, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open V2 report file: %w", err)
-	}
-	defer f.Close()
-
-	data, err := json.Marshal(target)
-	if err != nil {
-		return fmt.Errorf("failed to marshal V2 target: %w", err)
-	}
-
-	if _, err := f.Write(append(data, '\n')); err != nil {
-		return fmt.Errorf("failed to write V2 target: %w", err)
-	}
-
-	return nil
+	return RecorderFromContext(ctx).AddVersionReportV2Target(ctx, target)
 }
 
-// GetVersionReportV2 reads all V2 target reports from the file and returns them
-// as a VersionReportV2Data struct. Returns nil if the file doesn't exist or
-// the V1 environment variable is not set.
+// GetVersionReportV2 reads all V2 target reports from the active sink and returns
+// them as a VersionReportV2Data struct. Returns nil if no targets have been
+// written or no report location is set.
 func GetVersionReportV2() (*VersionReportV2Data, error) {
-	location := getV2Location()
-	if len(location) == 0 {
-		return nil, nil // V1 not configured
-	}
-
-	v2FileMutex.Lock()
-	defer v2FileMutex.Unlock()
+	return readVersionReportV2(context.Background(), activeSink())
+}
 
-	contents, err := os.ReadFile(location)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil // File doesn't exist, not an error
-		}
-		return nil, fmt.Errorf("failed to read V2 report file: %w", err)
+func readVersionReportV2(ctx context.Context, sink ReportSink) (*VersionReportV2Data, error) {
+	if sink == nil {
+		return nil, nil // Reporting not configured
 	}
 
-	if len(contents) == 0 {
-		return nil, nil // Empty file
+	targets, err := sink.ReadVersionReportV2Targets(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	decoder := json.NewDecoder(bytes.NewReader(contents))
-	targets := make([]VersionReportV2Target, 0)
-
-	for decoder.More() {
-		var target VersionReportV2Target
-		if err := decoder.Decode(&target); err != nil {
-			return nil, fmt.Errorf("failed to decode V2 target: %w", err)
-		}
-		targets = append(targets, target)
-	}
+	return newVersionReportV2Data(targets), nil
+}
 
+// newVersionReportV2Data returns nil if there are no targets.
+func newVersionReportV2Data(targets []VersionReportV2Target) *VersionReportV2Data {
 	if len(targets) == 0 {
-		return nil, nil
+		return nil
 	}
 
-	return &VersionReportV2Data{Targets: targets}, nil
+	return &VersionReportV2Data{Targets: targets}
 }
@@ -5,9 +5,13 @@ package versioning
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -410,3 +414,315 @@ func TestGetVersionReportV2_FileNotExist(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Nil(t, data)
 }
+
+func TestConcurrentSubprocessWriters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("spawns many writer processes")
+	}
+
+	const (
+		writers = 32
+		records = 20
+		size    = 64 * 1024 // well beyond PIPE_BUF, so appends are not atomic by themselves
+	)
+
+	binary := filepath.Join(t.TempDir(), "stresswriter")
+	build := exec.Command("go", "build", "-o", binary, "testdata/stresswriter.go")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	ctx := context.Background()
+	type unknown struct{}
+
+	versionReports, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				cmd := exec.Command(binary, fmt.Sprint(w), fmt.Sprint(records), fmt.Sprint(size))
+				cmd.Env = append(os.Environ(), ENV_VAR_PREFIX+"="+os.Getenv(ENV_VAR_PREFIX))
+				if out, err := cmd.CombinedOutput(); err != nil {
+					errs <- fmt.Errorf("writer %d: %w: %s", w, err, out)
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		return nil, <-errs
+	})
+
+	require.NoError(t, err)
+	require.NotNil(t, versionReports)
+	require.NotNil(t, versionReports.V1)
+	require.NotNil(t, versionReports.V2)
+	assert.Len(t, versionReports.V1.Reports, writers*records)
+	assert.Len(t, versionReports.V2.Targets, writers*records)
+
+	for _, report := range versionReports.V1.Reports {
+		require.Len(t, report.PRReport, size, report.Key)
+		assert.Equal(t, strings.Repeat(report.Key, size/len(report.Key)+1)[:size], report.PRReport)
+	}
+	for _, target := range versionReports.V2.Targets {
+		require.Len(t, target.PackageName, size, target.TargetName)
+		assert.Equal(t, strings.Repeat(target.TargetName, size/len(target.TargetName)+1)[:size], target.PackageName)
+	}
+}
+
+func TestNestedWithVersionReportCapture(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	ctx := context.Background()
+	type unknown struct{}
+
+	var inner *VersionReportCapture
+	outer, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+		outerLocation := os.Getenv(ENV_VAR_PREFIX)
+		if err := execSubprocess(0, "outer"); err != nil {
+			return nil, err
+		}
+
+		var err error
+		inner, _, err = WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+			assert.NotEqual(t, outerLocation, os.Getenv(ENV_VAR_PREFIX))
+			if err := execSubprocess(1, "inner"); err != nil {
+				return nil, err
+			}
+			return nil, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"})
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The enclosing capture's destination is restored after the inner one.
+		assert.Equal(t, outerLocation, os.Getenv(ENV_VAR_PREFIX))
+		return nil, AddVersionReport(ctx, VersionReport{Key: "in-process", Priority: 1})
+	})
+
+	require.NoError(t, err)
+	require.NotNil(t, inner)
+	require.Len(t, inner.V1.Reports, 1)
+	assert.Equal(t, "subprocess2", inner.V1.Reports[0].Key)
+	require.NotNil(t, inner.V2)
+	require.Len(t, inner.V2.Targets, 1)
+
+	require.NotNil(t, outer)
+	require.Len(t, outer.V1.Reports, 3)
+	assert.Equal(t, "subprocess2", outer.V1.Reports[0].Key)
+	assert.Equal(t, "subprocess1", outer.V1.Reports[1].Key)
+	assert.Equal(t, "in-process", outer.V1.Reports[2].Key)
+	require.NotNil(t, outer.V2)
+	require.Len(t, outer.V2.Targets, 1)
+	assert.Equal(t, "go", outer.V2.Targets[0].TargetName)
+
+	_, wasSet := os.LookupEnv(ENV_VAR_PREFIX)
+	assert.False(t, wasSet)
+}
+
+func TestWithVersionReportCaptureCleanup(t *testing.T) {
+	type unknown struct{}
+	errGenerate := errors.New("generation failed")
+	outer := filepath.Join(t.TempDir(), "outer.json")
+
+	environs := []struct {
+		name     string
+		previous *string
+	}{
+		{name: "unset"},
+		{name: "empty", previous: new(string)},
+		{name: "set", previous: &outer},
+	}
+	runs := []struct {
+		name string
+		f    func(ctx context.Context) (*unknown, error)
+	}{
+		{name: "success", f: func(ctx context.Context) (*unknown, error) { return nil, nil }},
+		{name: "error", f: func(ctx context.Context) (*unknown, error) { return nil, errGenerate }},
+		{name: "panic", f: func(ctx context.Context) (*unknown, error) { panic(errGenerate) }},
+	}
+
+	for _, environ := range environs {
+		for _, run := range runs {
+			t.Run(environ.name+"/"+run.name, func(t *testing.T) {
+				if environ.previous == nil {
+					os.Unsetenv(ENV_VAR_PREFIX)
+				} else {
+					os.Setenv(ENV_VAR_PREFIX, *environ.previous)
+				}
+				defer os.Unsetenv(ENV_VAR_PREFIX)
+
+				var location string
+				capture := func() {
+					_, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (*unknown, error) {
+						location = os.Getenv(ENV_VAR_PREFIX)
+						require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "test", PRReport: "Test report"}))
+						require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+						_, err := os.Stat(getV2Location())
+						require.NoError(t, err)
+						return run.f(ctx)
+					})
+					if run.name == "error" {
+						assert.ErrorIs(t, err, errGenerate)
+					} else {
+						assert.NoError(t, err)
+					}
+				}
+				if run.name == "panic" {
+					assert.PanicsWithValue(t, errGenerate, capture)
+				} else {
+					capture()
+				}
+
+				value, ok := os.LookupEnv(ENV_VAR_PREFIX)
+				if environ.previous == nil {
+					assert.False(t, ok, "the variable is unset again")
+				} else {
+					assert.True(t, ok)
+					assert.Equal(t, *environ.previous, value)
+				}
+				_, err := os.Stat(filepath.Dir(location))
+				assert.True(t, os.IsNotExist(err), "the V1 and V2 temporary files are removed")
+			})
+		}
+	}
+}
+
+func TestWithVersionReportCaptureForwardsReportsOnPanic(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	type unknown struct{}
+	errGenerate := errors.New("generation failed")
+
+	outer, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (*unknown, error) {
+		assert.PanicsWithValue(t, errGenerate, func() {
+			WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+				require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "typescript", PRReport: "Before the panic"}))
+				require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "typescript", NewVersion: "1.1.0"}))
+				panic(errGenerate)
+			})
+		})
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	require.Len(t, outer.V1.Reports, 1)
+	assert.Equal(t, "Before the panic", outer.V1.Reports[0].PRReport)
+	require.NotNil(t, outer.V2)
+	assert.Len(t, outer.V2.Targets, 1)
+}
+
+func TestWithVersionReportCaptureReturnsPartialReports(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	type unknown struct{}
+	errGenerate := errors.New("generation failed")
+
+	var inner *VersionReportCapture
+	outer, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (*unknown, error) {
+		var err error
+		inner, _, err = WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+			require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "typescript", Priority: 1, PRReport: "Before the failure"}))
+			require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "typescript", NewVersion: "1.1.0"}))
+			return nil, errGenerate
+		})
+		assert.Equal(t, errGenerate, err, "the error of f is returned as is")
+		return nil, nil
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, inner)
+	require.Len(t, inner.V1.Reports, 1)
+	assert.Equal(t, "Before the failure", inner.V1.Reports[0].PRReport)
+	require.NotNil(t, inner.V2)
+	assert.Equal(t, "1.1.0", inner.V2.Targets[0].NewVersion)
+
+	// The reports of the failed capture still reach the enclosing one.
+	require.Len(t, outer.V1.Reports, 1)
+	require.NotNil(t, outer.V2)
+	assert.Len(t, outer.V2.Targets, 1)
+}
+
+func TestWithVersionReportCaptureJoinsErrors(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	type unknown struct{}
+	errGenerate := errors.New("generation failed")
+
+	capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (*unknown, error) {
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "go", Priority: 1, PRReport: "Go report"}))
+		require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0"}))
+
+		// A subprocess crashing mid-write leaves a torn line in each file.
+		for _, location := range []string{os.Getenv(ENV_VAR_PREFIX), getV2Location()} {
+			f, err := os.OpenFile(location, os.O_APPEND|os.O_WRONLY, 0)
+			require.NoError(t, err)
+			_, err = f.WriteString(`{"key":"torn`)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		}
+		return nil, errGenerate
+	})
+
+	assert.ErrorIs(t, err, errGenerate)
+	assert.ErrorIs(t, err, ErrCorruptReport)
+	var kinds []ReportKind
+	var walk func(err error)
+	walk = func(err error) {
+		var decodeErr *DecodeError
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				walk(e)
+			}
+		} else if errors.As(err, &decodeErr) {
+			kinds = append(kinds, decodeErr.Kind)
+		}
+	}
+	walk(err)
+	assert.Equal(t, []ReportKind{ReportKindV1, ReportKindV2}, kinds)
+
+	require.NotNil(t, capture)
+	require.Len(t, capture.V1.Reports, 1)
+	assert.Equal(t, "Go report", capture.V1.Reports[0].PRReport)
+	require.NotNil(t, capture.V2)
+	assert.Equal(t, "go", capture.V2.Targets[0].TargetName)
+}
+
+func TestOverlappingWithVersionReportCaptures(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	type unknown struct{}
+
+	aStarted, bStarted, aDone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// A starts, then B starts, then A finishes before B.
+	go func() {
+		defer wg.Done()
+		defer close(aDone)
+		capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (*unknown, error) {
+			close(aStarted)
+			<-bStarted
+			return nil, AddVersionReport(ctx, VersionReport{Key: "a"})
+		})
+		assert.NoError(t, err)
+		assert.Len(t, capture.V1.Reports, 1)
+	}()
+	go func() {
+		defer wg.Done()
+		<-aStarted
+		capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (*unknown, error) {
+			location := os.Getenv(ENV_VAR_PREFIX)
+			close(bStarted)
+			<-aDone
+			// A ending must not point the environment away from B.
+			assert.Equal(t, location, os.Getenv(ENV_VAR_PREFIX))
+			return nil, AddVersionReport(context.Background(), VersionReport{Key: "b"})
+		})
+		assert.NoError(t, err)
+		require.Len(t, capture.V1.Reports, 1)
+		assert.Equal(t, "b", capture.V1.Reports[0].Key)
+	}()
+	wg.Wait()
+
+	_, ok := os.LookupEnv(ENV_VAR_PREFIX)
+	assert.False(t, ok, "the environment is restored once both captures are done")
+	assert.NoError(t, AddVersionReport(context.Background(), VersionReport{Key: "after"}))
+}
@@ -0,0 +1,302 @@
+package versioning
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version as defined by https://semver.org.
+type Version struct {
+	// Prefix is an optional leading "v", which is kept when the version is
+	// bumped.
+	Prefix     string
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string // dot-separated identifiers after "-"
+	Build      []string // dot-separated identifiers after "+"
+}
+
+// ParseVersion parses a semantic version such as "1.2.3-alpha.1+build.5",
+// optionally prefixed with "v". Errors match ErrInvalidVersion.
+func ParseVersion(s string) (Version, error) {
+	invalid := func(reason string) (Version, error) {
+		return Version{}, fmt.Errorf("%w %q: %s", ErrInvalidVersion, s, reason)
+	}
+
+	var v Version
+	rest := s
+	if strings.HasPrefix(rest, "v") {
+		v.Prefix, rest = "v", rest[1:]
+	}
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		build := rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(build, false) {
+			return invalid("malformed build metadata")
+		}
+		v.Build = strings.Split(build, ".")
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		prerelease := rest[i+1:]
+		rest = rest[:i]
+		if !validIdentifiers(prerelease, true) {
+			return invalid("malformed prerelease")
+		}
+		v.Prerelease = strings.Split(prerelease, ".")
+	}
+
+	core := strings.Split(rest, ".")
+	if len(core) != 3 {
+		return invalid("expected MAJOR.MINOR.PATCH")
+	}
+	numbers := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range core {
+		if !isNumeric(part) || (len(part) > 1 && part[0] == '0') {
+			return invalid("malformed version number " + strconv.Quote(part))
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return invalid(err.Error())
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%s%d.%d.%d", v.Prefix, v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Next returns the version that follows v for bump:
+//
+//   - BumpNone, or an empty bump type, returns v unchanged.
+//   - BumpMajor, BumpMinor and BumpPatch increment the corresponding number
+//     and reset the lower ones. Below 1.0.0 breaking changes only bump the
+//     minor version: 1.0.0 has to be chosen explicitly with BumpCustom.
+//   - On a prerelease, a standard bump that is already part of the upcoming
+//     release only advances the prerelease, so 1.2.0-alpha.3 becomes
+//     1.2.0-alpha.4 for BumpMinor or BumpPatch. Otherwise the core version
+//     is bumped and the prerelease restarts, so 1.2.3-alpha.3 becomes
+//     1.3.0-alpha.0 for BumpMinor.
+//   - BumpPrerelease increments the last prerelease identifier if it is
+//     numeric and appends ".0" otherwise. On a stable version it starts a
+//     prerelease of the next patch version: 1.2.3 becomes 1.2.4-0.
+//   - BumpGraduate drops the prerelease. A stable version is returned as is.
+//
+// Build metadata is dropped whenever the version changes. BumpCustom and
+// unknown bump types fail with ErrInvalidBump.
+func (v Version) Next(bump BumpType) (Version, error) {
+	next := v
+	next.Build = nil
+	next.Prerelease = append([]string(nil), v.Prerelease...)
+
+	switch bump = v.normalize(bump); bump {
+	case BumpNone, "":
+		return v, nil
+	case BumpGraduate:
+		if len(v.Prerelease) == 0 {
+			return v, nil
+		}
+		next.Prerelease = nil
+		return next, nil
+	case BumpPrerelease:
+		if len(v.Prerelease) == 0 {
+			next.Patch++
+			next.Prerelease = []string{"0"}
+			return next, nil
+		}
+		next.Prerelease = incrementPrerelease(next.Prerelease)
+		return next, nil
+	case BumpMajor, BumpMinor, BumpPatch:
+		if len(v.Prerelease) > 0 && prereleaseIncludes(v, bump) {
+			next.Prerelease = incrementPrerelease(next.Prerelease)
+			return next, nil
+		}
+		switch bump {
+		case BumpMajor:
+			next.Major, next.Minor, next.Patch = v.Major+1, 0, 0
+		case BumpMinor:
+			next.Minor, next.Patch = v.Minor+1, 0
+		case BumpPatch:
+			next.Patch = v.Patch + 1
+		}
+		if len(v.Prerelease) > 0 {
+			next.Prerelease = restartPrerelease(v.Prerelease)
+		}
+		return next, nil
+	default:
+		return Version{}, fmt.Errorf("%w: cannot compute the next version of %s for %q", ErrInvalidBump, v, bump)
+	}
+}
+
+// normalize applies the 0.x rule, under which breaking changes bump the minor
+// version.
+func (v Version) normalize(bump BumpType) BumpType {
+	if bump == BumpMajor && v.Major == 0 {
+		return BumpMinor
+	}
+	return bump
+}
+
+// graduateAndBump returns the version that follows v for both BumpGraduate and
+// the standard bump. If v is a prerelease that already covers bump, it is only
+// graduated, so 1.2.0-rc.1 becomes 1.2.0 for BumpMinor. Otherwise it is
+// graduated and then bumped, so 1.2.3-rc.1 becomes 1.3.0 for BumpMinor and
+// 1.2.3 becomes 2.0.0 for BumpMajor.
+func (v Version) graduateAndBump(bump BumpType) (Version, error) {
+	graduated, err := v.Next(BumpGraduate)
+	if err != nil {
+		return Version{}, err
+	}
+	if len(v.Prerelease) > 0 && prereleaseIncludes(v, v.normalize(bump)) {
+		return graduated, nil
+	}
+	return graduated.Next(bump)
+}
+
+// parsePrevious parses a previous version, treating an empty one as 0.0.0.
+func parsePrevious(previous string) (Version, error) {
+	if previous == "" {
+		previous = "0.0.0"
+	}
+	return ParseVersion(previous)
+}
+
+// NextVersion returns the version that follows previous for bump, following
+// the rules of Version.Next. An empty previous version is treated as 0.0.0.
+func NextVersion(previous string, bump BumpType) (string, error) {
+	v, err := parsePrevious(previous)
+	if err != nil {
+		return "", err
+	}
+	next, err := v.Next(bump)
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
+
+// NextVersion returns the version that follows previous for the effective
+// bump of the merged reports. When a graduation is requested along with a
+// standard bump, a prerelease that already covers the bump is only graduated,
+// so 1.2.0-rc.1 becomes 1.2.0 for BumpMinor; otherwise the version is
+// graduated and then bumped, so 1.2.3-rc.1 becomes 1.3.0 for BumpMinor. For
+// BumpCustom the NewVersion of the deciding report is returned, after
+// checking that it is a valid version.
+func (m *MergedVersionReport) NextVersion(previous string) (string, error) {
+	resolution := m.EffectiveBumpType()
+	if len(resolution.GraduateKey) > 0 {
+		v, err := parsePrevious(previous)
+		if err != nil {
+			return "", err
+		}
+		next, err := v.graduateAndBump(resolution.BumpType)
+		if err != nil {
+			return "", err
+		}
+		return next.String(), nil
+	}
+	if resolution.BumpType != BumpCustom {
+		return NextVersion(previous, resolution.BumpType)
+	}
+	for _, report := range m.Reports {
+		if report.Key != resolution.Key {
+			continue
+		}
+		if _, err := ParseVersion(report.NewVersion); err != nil {
+			return "", fmt.Errorf("custom version of %s: %w", report.Key, err)
+		}
+		return report.NewVersion, nil
+	}
+	return "", fmt.Errorf("%w: no report for key %s", ErrInvalidBump, resolution.Key)
+}
+
+// NextVersion returns the version that follows the PreviousVersion of the
+// target for bump.
+func (t *VersionReportV2Target) NextVersion(bump BumpType) (string, error) {
+	return NextVersion(t.PreviousVersion, bump)
+}
+
+// NextVersion returns the version that follows the PreviousVersion of the
+// named target for bump. If the target was reported more than once, the last
+// report is used.
+func (d *VersionReportV2Data) NextVersion(targetName string, bump BumpType) (string, error) {
+	for i := len(d.Targets) - 1; i >= 0; i-- {
+		if d.Targets[i].TargetName == targetName {
+			return d.Targets[i].NextVersion(bump)
+		}
+	}
+	return "", fmt.Errorf("no version report for target %s", targetName)
+}
+
+// prereleaseIncludes reports whether releasing the prerelease v already makes
+// a change that needs bump, for example 1.2.0-alpha.1 for BumpMinor.
+func prereleaseIncludes(v Version, bump BumpType) bool {
+	switch bump {
+	case BumpMajor:
+		return v.Minor == 0 && v.Patch == 0
+	case BumpMinor:
+		return v.Patch == 0
+	default:
+		return true
+	}
+}
+
+func incrementPrerelease(prerelease []string) []string {
+	last := prerelease[len(prerelease)-1]
+	if isNumeric(last) {
+		if n, err := strconv.ParseUint(last, 10, 64); err == nil {
+			prerelease[len(prerelease)-1] = strconv.FormatUint(n+1, 10)
+			return prerelease
+		}
+	}
+	return append(prerelease, "0")
+}
+
+// restartPrerelease keeps the labels of prerelease and resets its counter.
+func restartPrerelease(prerelease []string) []string {
+	if isNumeric(prerelease[len(prerelease)-1]) {
+		prerelease = prerelease[:len(prerelease)-1]
+	}
+	return append(append([]string(nil), prerelease...), "0")
+}
+
+// validIdentifiers reports whether s is a non-empty list of dot-separated
+// identifiers. Numeric prerelease identifiers must not have leading zeros.
+func validIdentifiers(s string, prerelease bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, r := range id {
+			if !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+				return false
+			}
+		}
+		if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
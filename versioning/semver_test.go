@@ -0,0 +1,123 @@
+package versioning
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v1.2.3-alpha.1+build.5")
+	require.NoError(t, err)
+	assert.Equal(t, Version{
+		Prefix:     "v",
+		Major:      1,
+		Minor:      2,
+		Patch:      3,
+		Prerelease: []string{"alpha", "1"},
+		Build:      []string{"build", "5"},
+	}, v)
+	assert.Equal(t, "v1.2.3-alpha.1+build.5", v.String())
+
+	for _, invalid := range []string{"", "1.2", "1.2.3.4", "01.2.3", "1.2.x", "1.2.3-", "1.2.3-alpha..1", "1.2.3-01", "1.2.3+", "1.2.3+b_1"} {
+		_, err := ParseVersion(invalid)
+		assert.ErrorIs(t, err, ErrInvalidVersion, invalid)
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	tests := []struct {
+		previous string
+		bump     BumpType
+		expected string
+	}{
+		{"1.2.3", BumpNone, "1.2.3"},
+		{"1.2.3+build.1", "", "1.2.3+build.1"},
+		{"1.2.3", BumpPatch, "1.2.4"},
+		{"1.2.3", BumpMinor, "1.3.0"},
+		{"1.2.3", BumpMajor, "2.0.0"},
+		{"v1.2.3", BumpMinor, "v1.3.0"},
+		{"1.2.3+build.1", BumpPatch, "1.2.4"},
+		{"", BumpPatch, "0.0.1"},
+
+		// 0.x
+		{"0.2.3", BumpMajor, "0.3.0"},
+		{"0.2.3", BumpMinor, "0.3.0"},
+		{"0.2.3", BumpPatch, "0.2.4"},
+
+		// prereleases
+		{"1.2.3-alpha.3", BumpPrerelease, "1.2.3-alpha.4"},
+		{"1.2.3-alpha", BumpPrerelease, "1.2.3-alpha.0"},
+		{"1.2.3-alpha.3+build.1", BumpPrerelease, "1.2.3-alpha.4"},
+		{"1.2.3", BumpPrerelease, "1.2.4-0"},
+		{"1.2.0-alpha.3", BumpMinor, "1.2.0-alpha.4"},
+		{"1.2.3-alpha.3", BumpPatch, "1.2.3-alpha.4"},
+		{"1.2.3-alpha.3", BumpMinor, "1.3.0-alpha.0"},
+		{"1.2.3-beta", BumpMajor, "2.0.0-beta.0"},
+		{"2.0.0-rc.1", BumpMajor, "2.0.0-rc.2"},
+		{"0.2.0-alpha.1", BumpMajor, "0.2.0-alpha.2"},
+
+		// graduation
+		{"1.2.3-alpha.3", BumpGraduate, "1.2.3"},
+		{"1.2.3-alpha.3+build.1", BumpGraduate, "1.2.3"},
+		{"1.2.3+build.1", BumpGraduate, "1.2.3+build.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.previous+" "+string(tt.bump), func(t *testing.T) {
+			next, err := NextVersion(tt.previous, tt.bump)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, next)
+		})
+	}
+}
+
+func TestNextVersionErrors(t *testing.T) {
+	_, err := NextVersion("1.2.3", BumpCustom)
+	assert.ErrorIs(t, err, ErrInvalidBump)
+
+	_, err = NextVersion("1.2.3", "experimental")
+	assert.ErrorIs(t, err, ErrInvalidBump)
+
+	_, err = NextVersion("latest", BumpPatch)
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+}
+
+func TestMergedVersionReportNextVersion(t *testing.T) {
+	merged := &MergedVersionReport{Reports: []VersionReport{
+		{Key: "a", BumpType: BumpPatch},
+		{Key: "b", BumpType: BumpMinor},
+	}}
+	next, err := merged.NextVersion("1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, "1.3.0", next)
+
+	merged.Reports = append(merged.Reports, VersionReport{Key: "c", BumpType: BumpCustom, NewVersion: "5.0.0"})
+	next, err = merged.NextVersion("1.2.3")
+	require.NoError(t, err)
+	assert.Equal(t, "5.0.0", next)
+
+	merged.Reports[2].NewVersion = "five"
+	_, err = merged.NextVersion("1.2.3")
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+}
+
+func TestVersionReportV2DataNextVersion(t *testing.T) {
+	data := &VersionReportV2Data{Targets: []VersionReportV2Target{
+		{TargetName: "go", PreviousVersion: "1.0.0"},
+		{TargetName: "typescript", PreviousVersion: "2.1.0-beta.1"},
+		{TargetName: "go", PreviousVersion: "1.1.0"},
+	}}
+
+	next, err := data.NextVersion("go", BumpMinor)
+	require.NoError(t, err)
+	assert.Equal(t, "1.2.0", next)
+
+	next, err = data.NextVersion("typescript", BumpPrerelease)
+	require.NoError(t, err)
+	assert.Equal(t, "2.1.0-beta.2", next)
+
+	_, err = data.NextVersion("python", BumpPatch)
+	assert.Error(t, err)
+}
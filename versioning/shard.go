@@ -0,0 +1,211 @@
+package versioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	v1ShardSuffix = ".v1.jsonl"
+	v2ShardSuffix = ".v2.jsonl"
+)
+
+// DirSink stores reports in a directory where every process appends to its
+// own shard files, named after its hostname and pid. Writers never contend
+// with each other, and reads merge the shards of every process in the order
+// the records were written.
+type DirSink struct {
+	Dir string
+	// FileOptions apply to every shard.
+	FileOptions
+}
+
+func NewDirSink(dir string) *DirSink {
+	return &DirSink{Dir: dir}
+}
+
+// shardName identifies the current process within a shared directory.
+func shardName() string {
+	host, err := os.Hostname()
+	if err != nil || len(host) == 0 {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", "_", `\`, "_", string(filepath.Separator), "_").Replace(host)
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// shard returns the sink for the current process's shard files.
+func (s *DirSink) shard() (*FileSink, error) {
+	if err := os.MkdirAll(s.Dir, s.dirMode()); err != nil {
+		return nil, fmt.Errorf("failed to create report directory: %w", err)
+	}
+	name := shardName()
+	return &FileSink{
+		Location:    filepath.Join(s.Dir, name+v1ShardSuffix),
+		V2Location:  filepath.Join(s.Dir, name+v2ShardSuffix),
+		FileOptions: s.FileOptions,
+	}, nil
+}
+
+// shards lists the shard files with the given suffix, in a stable order.
+func (s *DirSink) shards(suffix string) ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list report directory: %w", err)
+	}
+
+	var shards []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), suffix) {
+			shards = append(shards, filepath.Join(s.Dir, entry.Name()))
+		}
+	}
+	sort.Strings(shards)
+	return shards, nil
+}
+
+func (s *DirSink) WriteVersionReport(ctx context.Context, report VersionReport) error {
+	shard, err := s.shard()
+	if err != nil {
+		return err
+	}
+	return shard.WriteVersionReport(ctx, report)
+}
+
+func (s *DirSink) WriteVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
+	shard, err := s.shard()
+	if err != nil {
+		return err
+	}
+	return shard.WriteVersionReportV2Target(ctx, target)
+}
+
+func (s *DirSink) ReadVersionReports(ctx context.Context) ([]VersionReport, error) {
+	reports, _, err := collect(s.StreamVersionReports(ctx), false)
+	return reports, err
+}
+
+func (s *DirSink) ReadVersionReportsLenient(ctx context.Context) ([]VersionReport, []ReadDiagnostic, error) {
+	return collect(s.StreamVersionReports(ctx), true)
+}
+
+func (s *DirSink) ReadVersionReportV2Targets(ctx context.Context) ([]VersionReportV2Target, error) {
+	targets, _, err := collect(s.StreamVersionReportV2Targets(ctx), false)
+	return targets, err
+}
+
+func (s *DirSink) ReadVersionReportV2TargetsLenient(ctx context.Context) ([]VersionReportV2Target, []ReadDiagnostic, error) {
+	return collect(s.StreamVersionReportV2Targets(ctx), true)
+}
+
+// StreamVersionReports merges the V1 shards of every process one record at a time.
+func (s *DirSink) StreamVersionReports(ctx context.Context) func(yield func(VersionReport, error) bool) {
+	return func(yield func(VersionReport, error) bool) {
+		streamShards(s, v1ShardSuffix, ReportKindV1, yield)
+	}
+}
+
+// StreamVersionReportV2Targets merges the V2 shards of every process one record at a time.
+func (s *DirSink) StreamVersionReportV2Targets(ctx context.Context) func(yield func(VersionReportV2Target, error) bool) {
+	return func(yield func(VersionReportV2Target, error) bool) {
+		streamShards(s, v2ShardSuffix, ReportKindV2, yield)
+	}
+}
+
+// shardHead is the next record of a shard that has not been yielded yet.
+type shardHead[T any] struct {
+	cursor   *recordCursor[T]
+	record   T
+	envelope envelope
+}
+
+// streamShards merges the shards with the given suffix by the time their
+// records were written. Records of a single shard always keep their order, and
+// records written at the same instant are ordered by shard name.
+func streamShards[T any](s *DirSink, suffix string, kind ReportKind, yield func(T, error) bool) {
+	var zero T
+
+	shards, err := s.shards(suffix)
+	if err != nil {
+		yield(zero, err)
+		return
+	}
+
+	heads := make([]*shardHead[T], 0, len(shards))
+	defer func() {
+		for _, head := range heads {
+			head.cursor.Close()
+		}
+	}()
+
+	// advance moves head to its next record, yielding any decode errors on the
+	// way. It returns false if the head is exhausted or the caller stopped.
+	advance := func(head *shardHead[T]) (ok bool, stop bool) {
+		for {
+			record, env, err := head.cursor.next()
+			if err == io.EOF {
+				return false, false
+			}
+			if err != nil {
+				if !yield(zero, err) || !errors.Is(err, ErrCorruptReport) {
+					return false, true
+				}
+				continue
+			}
+			head.record, head.envelope = record, env
+			return true, false
+		}
+	}
+
+	for _, shard := range shards {
+		cursor, err := openRecordCursor[T](shard, kind)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // removed since it was listed
+			}
+			yield(zero, err)
+			return
+		}
+		head := &shardHead[T]{cursor: cursor}
+		heads = append(heads, head)
+		ok, stop := advance(head)
+		if stop {
+			return
+		}
+		if !ok {
+			heads = heads[:len(heads)-1]
+			cursor.Close()
+		}
+	}
+
+	for len(heads) > 0 {
+		next := 0
+		for i, head := range heads {
+			if head.envelope.WrittenAt.Before(heads[next].envelope.WrittenAt) {
+				next = i
+			}
+		}
+
+		head := heads[next]
+		if !yield(head.record, nil) {
+			return
+		}
+		ok, stop := advance(head)
+		if stop {
+			return
+		}
+		if !ok {
+			head.cursor.Close()
+			heads = append(heads[:next], heads[next+1:]...)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV2LocationEnvVar(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	v2Location := filepath.Join(t.TempDir(), "elsewhere", "changes.jsonl")
+	require.NoError(t, os.MkdirAll(filepath.Dir(v2Location), 0755))
+
+	os.Setenv(V2_LOCATION_ENV_VAR, v2Location)
+	defer os.Unsetenv(V2_LOCATION_ENV_VAR)
+
+	ctx := context.Background()
+	// V1 stays disabled while V2 is enabled on its own.
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "test"}))
+	require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0"}))
+	assert.Equal(t, v2Location, getV2Location())
+
+	data, err := GetVersionReportV2()
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Equal(t, "go", data.Targets[0].TargetName)
+	assert.FileExists(t, v2Location)
+
+	_, err = getMergedVersionReport()
+	assert.ErrorIs(t, err, ErrLocationNotSet)
+
+	// An explicit V2 location also overrides the derived one.
+	v1Location := filepath.Join(t.TempDir(), "version.json")
+	os.Setenv(ENV_VAR_PREFIX, v1Location)
+	defer os.Unsetenv(ENV_VAR_PREFIX)
+	assert.Equal(t, v2Location, getV2Location())
+}
+
+func TestDirSinkWithSubprocesses(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	dir := t.TempDir()
+	os.Setenv(DIR_ENV_VAR, dir)
+	defer os.Unsetenv(DIR_ENV_VAR)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, execSubprocess(i, "original"))
+	}
+	require.NoError(t, execSubprocess(0, "overridden"))
+	require.NoError(t, AddVersionReportV2Target(context.Background(), VersionReportV2Target{TargetName: "go"}))
+
+	// Every process wrote its own shard.
+	shards, err := filepath.Glob(filepath.Join(dir, "*"+v1ShardSuffix))
+	require.NoError(t, err)
+	assert.Len(t, shards, 3)
+	assert.FileExists(t, filepath.Join(dir, shardName()+v2ShardSuffix))
+
+	merged, err := getMergedVersionReport()
+	require.NoError(t, err)
+	require.Len(t, merged.Reports, 2)
+	assert.Equal(t, "subprocess1", merged.Reports[0].Key)
+	assert.Equal(t, "overridden", merged.Reports[0].PRReport)
+	assert.Equal(t, "subprocess2", merged.Reports[1].Key)
+
+	data, err := GetVersionReportV2()
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Equal(t, "go", data.Targets[0].TargetName)
+}
+
+func TestDirSinkMergesShardsByWriteTime(t *testing.T) {
+	dir := t.TempDir()
+	line := func(key, writtenAt string) string {
+		return `{"_envelope":{"schema_version":2,"kind":"v1","written_at":"` + writtenAt + `"},"key":"` + key + `"}` + "\n"
+	}
+	shardA := line("a1", "2024-01-01T00:00:01Z") + line("a2", "2024-01-01T00:00:04Z") + "{broken\n"
+	shardB := line("b1", "2024-01-01T00:00:02Z") + line("b2", "2024-01-01T00:00:03Z") + line("b3", "2024-01-01T00:00:05Z")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "host-1"+v1ShardSuffix), []byte(shardA), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "host-2"+v1ShardSuffix), []byte(shardB), 0644))
+	// Unrelated files are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0644))
+
+	sink := NewDirSink(dir)
+	ctx := context.Background()
+
+	_, err := sink.ReadVersionReports(ctx)
+	assert.ErrorIs(t, err, ErrCorruptReport)
+
+	reports, diagnostics, err := sink.ReadVersionReportsLenient(ctx)
+	require.NoError(t, err)
+	var keys []string
+	for _, report := range reports {
+		keys = append(keys, report.Key)
+	}
+	assert.Equal(t, []string{"a1", "b1", "b2", "a2", "b3"}, keys)
+	require.Len(t, diagnostics, 1)
+	assert.True(t, strings.HasSuffix(diagnostics[0].Location, "host-1"+v1ShardSuffix))
+	assert.Equal(t, 3, diagnostics[0].Line)
+}
+
+func TestDirSinkMissingDirectory(t *testing.T) {
+	sink := NewDirSink(filepath.Join(t.TempDir(), "missing"))
+	ctx := context.Background()
+
+	reports, err := sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, reports)
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test"}))
+	reports, err = sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+}
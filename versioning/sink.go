@@ -0,0 +1,452 @@
+package versioning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ReportSink is a destination for V1 version reports and V2 targets.
+// Implementations must be safe for concurrent use.
+type ReportSink interface {
+	// WriteVersionReport records a single V1 report.
+	WriteVersionReport(ctx context.Context, report VersionReport) error
+	// WriteVersionReportV2Target records a single V2 target.
+	WriteVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error
+	// ReadVersionReports returns every V1 report in the order it was written.
+	ReadVersionReports(ctx context.Context) ([]VersionReport, error)
+	// ReadVersionReportV2Targets returns every V2 target in the order it was written.
+	ReadVersionReportV2Targets(ctx context.Context) ([]VersionReportV2Target, error)
+}
+
+// FILE_MODE_ENV_VAR holds the octal permission for report files created by
+// subprocesses (see FileOptions.FileMode).
+const FILE_MODE_ENV_VAR = "SPEAKEASY_VERSION_REPORT_FILE_MODE"
+
+var (
+	fileMutex   sync.Mutex
+	v2FileMutex sync.Mutex
+)
+
+// FileSink appends reports as JSON lines to a V1 file and a separate V2 file.
+// This is the default sink, used with the locations in ENV_VAR_PREFIX and
+// V2_LOCATION_ENV_VAR. Either location may be empty, in which case records of
+// that kind are not written.
+type FileSink struct {
+	Location   string
+	V2Location string
+	FileOptions
+}
+
+// FileOptions controls how FileSink and DirSink maintain their files.
+// Subprocesses inherit them through the environment along with the location.
+type FileOptions struct {
+	// Framed writes every record with a length and checksum header, so that
+	// torn writes are detected instead of being decoded by accident.
+	Framed bool
+	// CompactThreshold compacts a file once an append makes it at least this
+	// many bytes long. Zero disables automatic compaction.
+	CompactThreshold int64
+	// V2Compaction decides which V2 targets survive a compaction.
+	V2Compaction V2CompactionPolicy
+	// FileMode is the permission of newly created report files, 0644 if
+	// unset. Existing files keep their permissions.
+	FileMode os.FileMode
+	// Limits bounds the size of records and files.
+	Limits Limits
+	// MergeStrategies sets how V1 reports read from the files are merged, by
+	// key or by key prefix ending in "*", ahead of those registered with
+	// SetMergeStrategy. Compaction keeps every record of the keys merged with
+	// a strategy other than MergeLastWins.
+	MergeStrategies map[string]MergeStrategy
+
+	// keepHistory is set for the files of captures made WithHistory, which
+	// keep every V1 record.
+	keepHistory bool
+}
+
+// fileMode returns the permission for new report files.
+func (o FileOptions) fileMode() os.FileMode {
+	if o.FileMode == 0 {
+		return 0644
+	}
+	return o.FileMode.Perm()
+}
+
+// dirMode returns the permission for new report directories, which can be
+// listed by whoever can read the files in them.
+func (o FileOptions) dirMode() os.FileMode {
+	mode := o.fileMode()
+	return mode | (mode&0444)>>2
+}
+
+// fileOptionsFromEnv reads the FileOptions advertised to this process.
+func fileOptionsFromEnv() FileOptions {
+	framed, _ := strconv.ParseBool(os.Getenv(FRAMED_ENV_VAR))
+	threshold, _ := strconv.ParseInt(os.Getenv(COMPACT_THRESHOLD_ENV_VAR), 10, 64)
+	mode, _ := strconv.ParseUint(os.Getenv(FILE_MODE_ENV_VAR), 8, 32)
+	return FileOptions{
+		Framed:           framed,
+		CompactThreshold: threshold,
+		V2Compaction:     V2CompactionPolicy(os.Getenv(V2_COMPACTION_ENV_VAR)),
+		FileMode:         os.FileMode(mode).Perm(),
+		Limits:           parseLimits(os.Getenv(LIMITS_ENV_VAR)),
+		MergeStrategies:  parseMergeStrategies(os.Getenv(MERGE_STRATEGIES_ENV_VAR)),
+	}
+}
+
+// environ returns the environment entries advertising o to subprocesses.
+func (o FileOptions) environ() []string {
+	var env []string
+	if o.Framed {
+		env = append(env, FRAMED_ENV_VAR+"=true")
+	}
+	if o.CompactThreshold > 0 {
+		env = append(env, COMPACT_THRESHOLD_ENV_VAR+"="+strconv.FormatInt(o.CompactThreshold, 10))
+	}
+	if len(o.V2Compaction) > 0 {
+		env = append(env, V2_COMPACTION_ENV_VAR+"="+string(o.V2Compaction))
+	}
+	if o.FileMode != 0 {
+		env = append(env, FILE_MODE_ENV_VAR+"="+strconv.FormatUint(uint64(o.FileMode.Perm()), 8))
+	}
+	if o.Limits != (Limits{}) {
+		if limits, err := json.Marshal(o.Limits); err == nil {
+			env = append(env, LIMITS_ENV_VAR+"="+string(limits))
+		}
+	}
+	if len(o.MergeStrategies) > 0 {
+		if strategies, err := json.Marshal(o.MergeStrategies); err == nil {
+			env = append(env, MERGE_STRATEGIES_ENV_VAR+"="+string(strategies))
+		}
+	}
+	return env
+}
+
+// fileOptionsOf returns the FileOptions of sink, if it writes files.
+func fileOptionsOf(sink ReportSink) FileOptions {
+	switch sink := sink.(type) {
+	case *FileSink:
+		return sink.FileOptions
+	case *DirSink:
+		return sink.FileOptions
+	case *limitedSink:
+		return sink.limits.FileOptions
+	}
+	return FileOptions{}
+}
+
+// NewFileSink returns a FileSink writing V1 reports to location and V2 targets
+// to the location derived from it (e.g. "version.json" -> "version.v2.json").
+func NewFileSink(location string) *FileSink {
+	return &FileSink{Location: location, V2Location: v2LocationFor(location)}
+}
+
+func (s *FileSink) WriteVersionReport(ctx context.Context, report VersionReport) error {
+	if len(s.Location) == 0 {
+		return nil
+	}
+
+	report, bytes, err := encodeLimited(s, s.Location, ReportKindV1, report)
+	if err != nil {
+		return err
+	}
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+
+	f, err := openLocked(s.Location, os.O_APPEND|os.O_CREATE|os.O_RDWR, s.fileMode(), true)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer unlockFile(f)
+
+	if err := terminateTornLine(f); err != nil {
+		return err
+	}
+	if bytes, err = fitFile(s, f, s.Location, ReportKindV1, report, bytes); err != nil {
+		return err
+	}
+	if _, err := f.Write(append(bytes, '\n')); err != nil {
+		return err
+	}
+	s.compactIfNeeded(f, s.Location, ReportKindV1)
+	return nil
+}
+
+func (s *FileSink) WriteVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
+	if len(s.V2Location) == 0 {
+		return nil
+	}
+
+	target, err := s.limitOperations(s.V2Location, target)
+	if err != nil {
+		return err
+	}
+	target, data, err := encodeLimited(s, s.V2Location, ReportKindV2, target)
+	if err != nil {
+		return err
+	}
+
+	v2FileMutex.Lock()
+	defer v2FileMutex.Unlock()
+
+	f, err := openLocked(s.V2Location, os.O_APPEND|os.O_CREATE|os.O_RDWR, s.fileMode(), true)
+	if err != nil {
+		return fmt.Errorf("failed to open V2 report file: %w", err)
+	}
+	defer f.Close()
+	defer unlockFile(f)
+
+	if err := terminateTornLine(f); err != nil {
+		return fmt.Errorf("failed to write V2 target: %w", err)
+	}
+	if data, err = fitFile(s, f, s.V2Location, ReportKindV2, target, data); err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write V2 target: %w", err)
+	}
+	s.compactIfNeeded(f, s.V2Location, ReportKindV2)
+
+	return nil
+}
+
+// terminateTornLine ends the last line of f if a writer crashed before writing
+// its newline, so that the next record starts on a line of its own rather than
+// being lost with the torn one. The caller holds the file's exclusive lock.
+func terminateTornLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = f.Write([]byte{'\n'})
+	return err
+}
+
+func (s *FileSink) ReadVersionReports(ctx context.Context) ([]VersionReport, error) {
+	reports, _, err := collect(s.StreamVersionReports(ctx), false)
+	return reports, err
+}
+
+func (s *FileSink) ReadVersionReportsLenient(ctx context.Context) ([]VersionReport, []ReadDiagnostic, error) {
+	return collect(s.StreamVersionReports(ctx), true)
+}
+
+// ReadVersionReportV2Targets returns nil if the V2 file does not exist yet.
+func (s *FileSink) ReadVersionReportV2Targets(ctx context.Context) ([]VersionReportV2Target, error) {
+	targets, _, err := collect(s.StreamVersionReportV2Targets(ctx), false)
+	return targets, err
+}
+
+func (s *FileSink) ReadVersionReportV2TargetsLenient(ctx context.Context) ([]VersionReportV2Target, []ReadDiagnostic, error) {
+	return collect(s.StreamVersionReportV2Targets(ctx), true)
+}
+
+// StreamVersionReports decodes the V1 file one record at a time.
+func (s *FileSink) StreamVersionReports(ctx context.Context) func(yield func(VersionReport, error) bool) {
+	return func(yield func(VersionReport, error) bool) {
+		if len(s.Location) == 0 {
+			yield(VersionReport{}, ErrLocationNotSet)
+			return
+		}
+		streamFile(s.Location, ReportKindV1, false, yield)
+	}
+}
+
+// StreamVersionReportV2Targets decodes the V2 file one record at a time. It
+// yields nothing if the V2 file does not exist yet.
+func (s *FileSink) StreamVersionReportV2Targets(ctx context.Context) func(yield func(VersionReportV2Target, error) bool) {
+	return func(yield func(VersionReportV2Target, error) bool) {
+		if len(s.V2Location) == 0 {
+			return
+		}
+		streamFile(s.V2Location, ReportKindV2, true, func(target VersionReportV2Target, err error) bool {
+			if err != nil && !errors.Is(err, ErrCorruptReport) {
+				err = fmt.Errorf("failed to read V2 report file: %w", err)
+			}
+			return yield(target, err)
+		})
+	}
+}
+
+// openLocked opens the file at location and takes an advisory lock on it, an
+// exclusive one for writers so that appends from other processes cannot
+// interleave. A compaction may replace the file while we wait for the lock, so
+// the lock is only kept once the open file is still the one at location;
+// otherwise the new file is opened, and nothing is written to or read from an
+// unlinked file.
+//
+// Symlinks and anything but regular files are refused with
+// ErrUnsafeReportFile, as are files owned by another user when exclusive is
+// set. New files are created with perm.
+func openLocked(location string, flag int, perm os.FileMode, exclusive bool) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(location, flag|noFollow, perm)
+		if err != nil {
+			if isSymlink(location) {
+				return nil, fmt.Errorf("%w: %s is a symlink", ErrUnsafeReportFile, location)
+			}
+			return nil, err
+		}
+		if err := lockFile(f, exclusive); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		opened, err := f.Stat()
+		if err == nil {
+			err = checkOpened(location, opened, exclusive)
+		}
+		if err != nil {
+			unlockFile(f)
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Lstat(location)
+		if err == nil && os.SameFile(opened, current) {
+			return f, nil
+		}
+		unlockFile(f)
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// checkOpened checks that the file opened at location is safe to use.
+func checkOpened(location string, info os.FileInfo, exclusive bool) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s is not a regular file", ErrUnsafeReportFile, location)
+	}
+	if isSymlink(location) {
+		return fmt.Errorf("%w: %s is a symlink", ErrUnsafeReportFile, location)
+	}
+	if exclusive {
+		return checkOwner(location, info)
+	}
+	return nil
+}
+
+func isSymlink(location string) bool {
+	info, err := os.Lstat(location)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// MemorySink keeps reports in memory. It is mostly useful in unit tests.
+type MemorySink struct {
+	mu      sync.Mutex
+	reports []VersionReport
+	targets []VersionReportV2Target
+	// changed is closed by the next write, to wake up watchers.
+	changed chan struct{}
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) WriteVersionReport(ctx context.Context, report VersionReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports = append(s.reports, report)
+	s.notifyLocked()
+	return nil
+}
+
+func (s *MemorySink) WriteVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.targets = append(s.targets, target)
+	s.notifyLocked()
+	return nil
+}
+
+func (s *MemorySink) ReadVersionReports(ctx context.Context) ([]VersionReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]VersionReport(nil), s.reports...), nil
+}
+
+func (s *MemorySink) ReadVersionReportV2Targets(ctx context.Context) ([]VersionReportV2Target, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]VersionReportV2Target(nil), s.targets...), nil
+}
+
+// MultiSink fans writes out to several sinks. Reads are served by the first
+// sink, which is treated as the primary.
+type MultiSink struct {
+	sinks []ReportSink
+}
+
+func NewMultiSink(sinks ...ReportSink) *MultiSink {
+	return &MultiSink{sinks: sinks}
+}
+
+// WriteVersionReport writes to every sink, even if some of them fail.
+func (s *MultiSink) WriteVersionReport(ctx context.Context, report VersionReport) error {
+	var errs []error
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.WriteVersionReport(ctx, report))
+	}
+	return errors.Join(errs...)
+}
+
+// WriteVersionReportV2Target writes to every sink, even if some of them fail.
+func (s *MultiSink) WriteVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
+	var errs []error
+	for _, sink := range s.sinks {
+		errs = append(errs, sink.WriteVersionReportV2Target(ctx, target))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *MultiSink) ReadVersionReports(ctx context.Context) ([]VersionReport, error) {
+	if len(s.sinks) == 0 {
+		return nil, nil
+	}
+	return s.sinks[0].ReadVersionReports(ctx)
+}
+
+func (s *MultiSink) ReadVersionReportV2Targets(ctx context.Context) ([]VersionReportV2Target, error) {
+	if len(s.sinks) == 0 {
+		return nil, nil
+	}
+	return s.sinks[0].ReadVersionReportV2Targets(ctx)
+}
+
+func (s *MultiSink) ReadVersionReportsLenient(ctx context.Context) ([]VersionReport, []ReadDiagnostic, error) {
+	if len(s.sinks) == 0 {
+		return nil, nil, nil
+	}
+	if lenientSink, ok := s.sinks[0].(LenientReader); ok {
+		return lenientSink.ReadVersionReportsLenient(ctx)
+	}
+	reports, err := s.sinks[0].ReadVersionReports(ctx)
+	return reports, nil, err
+}
+
+func (s *MultiSink) ReadVersionReportV2TargetsLenient(ctx context.Context) ([]VersionReportV2Target, []ReadDiagnostic, error) {
+	if len(s.sinks) == 0 {
+		return nil, nil, nil
+	}
+	if lenientSink, ok := s.sinks[0].(LenientReader); ok {
+		return lenientSink.ReadVersionReportV2TargetsLenient(ctx)
+	}
+	targets, err := s.sinks[0].ReadVersionReportV2Targets(ctx)
+	return targets, nil, err
+}
@@ -0,0 +1,98 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemorySink(t *testing.T) {
+	sink := NewMemorySink()
+	SetReportSink(sink)
+	defer SetReportSink(nil)
+
+	ctx := context.Background()
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "test", Priority: 1, PRReport: "first"}))
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "test", Priority: 1, PRReport: "second"}))
+	require.NoError(t, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0"}))
+
+	reports, err := sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Equal(t, BumpNone, reports[0].BumpType)
+
+	merged, err := getMergedVersionReport()
+	require.NoError(t, err)
+	require.Len(t, merged.Reports, 1)
+	assert.Equal(t, "second", merged.Reports[0].PRReport)
+
+	data, err := GetVersionReportV2()
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Equal(t, "go", data.Targets[0].TargetName)
+}
+
+func TestWithVersionReportCaptureUsesConfiguredSink(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+	SetReportSink(NewMemorySink())
+	defer SetReportSink(nil)
+
+	ctx := context.Background()
+	type unknown struct{}
+	versionReports, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+		// No temporary file should be created for a configured sink.
+		assert.Empty(t, os.Getenv(ENV_VAR_PREFIX))
+		if err := AddVersionReport(ctx, VersionReport{Key: "test", MustGenerate: true}); err != nil {
+			return nil, err
+		}
+		return nil, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "typescript", NewVersion: "2.0.0"})
+	})
+
+	require.NoError(t, err)
+	require.NotNil(t, versionReports)
+	require.Len(t, versionReports.V1.Reports, 1)
+	assert.True(t, versionReports.V1.MustGenerate())
+	require.NotNil(t, versionReports.V2)
+	assert.Equal(t, "typescript", versionReports.V2.Targets[0].TargetName)
+}
+
+func TestMultiSink(t *testing.T) {
+	dir := t.TempDir()
+	file := NewFileSink(filepath.Join(dir, "version.json"))
+	memory := NewMemorySink()
+	sink := NewMultiSink(file, memory)
+
+	ctx := context.Background()
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test", PRReport: "report"}))
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+
+	for _, s := range []ReportSink{file, memory, sink} {
+		reports, err := s.ReadVersionReports(ctx)
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, "report", reports[0].PRReport)
+
+		targets, err := s.ReadVersionReportV2Targets(ctx)
+		require.NoError(t, err)
+		require.Len(t, targets, 1)
+		assert.Equal(t, "go", targets[0].TargetName)
+	}
+	assert.FileExists(t, filepath.Join(dir, "version.v2.json"))
+}
+
+func TestMultiSinkWritesToEverySinkOnError(t *testing.T) {
+	broken := NewFileSink(filepath.Join(t.TempDir(), "missing", "version.json"))
+	memory := NewMemorySink()
+	sink := NewMultiSink(broken, memory)
+
+	ctx := context.Background()
+	assert.Error(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test"}))
+
+	reports, err := memory.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	assert.Len(t, reports, 1)
+}
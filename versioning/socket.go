@@ -0,0 +1,252 @@
+package versioning
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SOCKET_ENV_VAR advertises the address of a Collector to subprocesses. When it
+// is set, reports are sent to the collector instead of being appended to the
+// file in ENV_VAR_PREFIX.
+const SOCKET_ENV_VAR = "SPEAKEASY_VERSION_REPORT_SOCKET"
+
+const (
+	collectorOpWriteV1 = "write_v1"
+	collectorOpWriteV2 = "write_v2"
+	collectorOpReadV1  = "read_v1"
+	collectorOpReadV2  = "read_v2"
+)
+
+// collectorRequest is a single newline-delimited message sent to a Collector.
+type collectorRequest struct {
+	Op     string                 `json:"op"`
+	Report *VersionReport         `json:"report,omitempty"`
+	Target *VersionReportV2Target `json:"target,omitempty"`
+	// Envelope carries the metadata of Report or Target.
+	Envelope *envelope `json:"envelope,omitempty"`
+}
+
+// collectorResponse acknowledges a collectorRequest once it has been applied.
+type collectorResponse struct {
+	Error   string                  `json:"error,omitempty"`
+	Reports []VersionReport         `json:"reports,omitempty"`
+	Targets []VersionReportV2Target `json:"targets,omitempty"`
+	// Envelopes carries the metadata of Reports or Targets, in the same order.
+	Envelopes []envelope `json:"envelopes,omitempty"`
+}
+
+// Collector receives reports from subprocesses over a Unix domain socket and
+// writes them to a sink as they arrive.
+type Collector struct {
+	sink     ReportSink
+	dir      string
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// StartCollector listens on a Unix domain socket in a fresh temporary directory
+// and forwards every report it receives to sink.
+func StartCollector(sink ReportSink) (*Collector, error) {
+	dir, err := os.MkdirTemp("", "version-report")
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "collector.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start report collector: %w", err)
+	}
+
+	c := &Collector{
+		sink:     sink,
+		dir:      dir,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	c.wg.Add(1)
+	go c.serve()
+	return c, nil
+}
+
+// Address returns the socket path to advertise in SOCKET_ENV_VAR.
+func (c *Collector) Address() string {
+	return c.listener.Addr().String()
+}
+
+// Close stops the collector and removes its socket. Reports that were
+// acknowledged before Close are guaranteed to have reached the sink.
+func (c *Collector) Close() error {
+	err := c.listener.Close()
+
+	c.mu.Lock()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	os.RemoveAll(c.dir)
+	return err
+}
+
+func (c *Collector) serve() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.conns[conn] = struct{}{}
+		c.mu.Unlock()
+
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.handle(conn)
+
+			c.mu.Lock()
+			delete(c.conns, conn)
+			c.mu.Unlock()
+		}()
+	}
+}
+
+func (c *Collector) handle(conn net.Conn) {
+	defer conn.Close()
+
+	ctx := context.Background()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	encoder := json.NewEncoder(conn)
+	for {
+		var req collectorRequest
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+
+		var resp collectorResponse
+		var err error
+		switch {
+		case req.Op == collectorOpWriteV1 && req.Report != nil:
+			if req.Envelope != nil {
+				applyEnvelope(req.Report, *req.Envelope)
+			}
+			err = c.sink.WriteVersionReport(ctx, *req.Report)
+		case req.Op == collectorOpWriteV2 && req.Target != nil:
+			if req.Envelope != nil {
+				applyEnvelope(req.Target, *req.Envelope)
+			}
+			err = c.sink.WriteVersionReportV2Target(ctx, *req.Target)
+		case req.Op == collectorOpReadV1:
+			resp.Reports, err = c.sink.ReadVersionReports(ctx)
+			for _, report := range resp.Reports {
+				resp.Envelopes = append(resp.Envelopes, newEnvelope(report, ReportKindV1))
+			}
+		case req.Op == collectorOpReadV2:
+			resp.Targets, err = c.sink.ReadVersionReportV2Targets(ctx)
+			for _, target := range resp.Targets {
+				resp.Envelopes = append(resp.Envelopes, newEnvelope(target, ReportKindV2))
+			}
+		default:
+			err = fmt.Errorf("unknown collector request %q", req.Op)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// SocketSink sends reports to a Collector listening at Address.
+type SocketSink struct {
+	Address string
+}
+
+func NewSocketSink(address string) *SocketSink {
+	return &SocketSink{Address: address}
+}
+
+func (s *SocketSink) WriteVersionReport(ctx context.Context, report VersionReport) error {
+	env := newEnvelope(report, ReportKindV1)
+	_, err := s.roundTrip(ctx, collectorRequest{Op: collectorOpWriteV1, Report: &report, Envelope: &env})
+	return err
+}
+
+func (s *SocketSink) WriteVersionReportV2Target(ctx context.Context, target VersionReportV2Target) error {
+	env := newEnvelope(target, ReportKindV2)
+	_, err := s.roundTrip(ctx, collectorRequest{Op: collectorOpWriteV2, Target: &target, Envelope: &env})
+	return err
+}
+
+func (s *SocketSink) ReadVersionReports(ctx context.Context) ([]VersionReport, error) {
+	resp, err := s.roundTrip(ctx, collectorRequest{Op: collectorOpReadV1})
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Envelopes {
+		if i < len(resp.Reports) {
+			applyEnvelope(&resp.Reports[i], resp.Envelopes[i])
+		}
+	}
+	return resp.Reports, nil
+}
+
+func (s *SocketSink) ReadVersionReportV2Targets(ctx context.Context) ([]VersionReportV2Target, error) {
+	resp, err := s.roundTrip(ctx, collectorRequest{Op: collectorOpReadV2})
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Envelopes {
+		if i < len(resp.Targets) {
+			applyEnvelope(&resp.Targets[i], resp.Envelopes[i])
+		}
+	}
+	return resp.Targets, nil
+}
+
+// roundTrip sends req on a fresh connection and waits for the collector to
+// acknowledge it, so a report is never lost when the sender exits right after.
+func (s *SocketSink) roundTrip(ctx context.Context, req collectorRequest) (*collectorResponse, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", s.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to report collector: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send report to collector: %w", err)
+	}
+
+	var resp collectorResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to read collector response: %w", err)
+	}
+	if len(resp.Error) > 0 {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,88 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	memory := NewMemorySink()
+	collector, err := StartCollector(memory)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	sink := NewSocketSink(collector.Address())
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test", PRReport: "report"}))
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0"}))
+
+	// Writes are acknowledged only once they have reached the sink.
+	reports, err := memory.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, "report", reports[0].PRReport)
+
+	reports, err = sink.ReadVersionReports(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, "test", reports[0].Key)
+
+	targets, err := sink.ReadVersionReportV2Targets(ctx)
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "go", targets[0].TargetName)
+
+	require.NoError(t, collector.Close())
+	assert.NoFileExists(t, collector.Address())
+	assert.Error(t, sink.WriteVersionReport(ctx, VersionReport{Key: "late"}))
+}
+
+func TestCollectorReturnsSinkErrors(t *testing.T) {
+	broken := NewFileSink(filepath.Join(t.TempDir(), "missing", "version.json"))
+	collector, err := StartCollector(broken)
+	require.NoError(t, err)
+	defer collector.Close()
+
+	err = NewSocketSink(collector.Address()).WriteVersionReport(context.Background(), VersionReport{Key: "test"})
+	assert.Error(t, err)
+}
+
+func TestIntegrationWithSocketCollector(t *testing.T) {
+	ctx := context.Background()
+	type unknown struct{}
+
+	versionReports, _, err := WithVersionReportCapture(ctx, func(ctx context.Context) (*unknown, error) {
+		// Nothing is written to a shared file when reports go through the collector.
+		assert.Empty(t, os.Getenv(ENV_VAR_PREFIX))
+		assert.NotEmpty(t, os.Getenv(SOCKET_ENV_VAR))
+
+		for i := 0; i < 2; i++ {
+			err := execSubprocess(i, "original")
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := execSubprocess(0, "overridden"); err != nil {
+			return nil, err
+		}
+		return nil, AddVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0"})
+	}, WithSocketCollector())
+
+	require.NoError(t, err)
+	require.NotNil(t, versionReports)
+	require.NotNil(t, versionReports.V1)
+	assert.Len(t, versionReports.V1.Reports, 2)
+	assert.Equal(t, "subprocess1", versionReports.V1.Reports[0].Key)
+	assert.Equal(t, "overridden", versionReports.V1.Reports[0].PRReport)
+	assert.Equal(t, "subprocess2", versionReports.V1.Reports[1].Key)
+	assert.Equal(t, "original", versionReports.V1.Reports[1].PRReport)
+	require.NotNil(t, versionReports.V2)
+	assert.Equal(t, "go", versionReports.V2.Targets[0].TargetName)
+
+	_, wasSet := os.LookupEnv(SOCKET_ENV_VAR)
+	assert.False(t, wasSet)
+}
@@ -0,0 +1,263 @@
+package versioning
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// StreamReader is implemented by sinks that can decode their records one at a
+// time instead of loading all of them into memory, such as FileSink.
+//
+// The returned functions have the same shape as iter.Seq2, so they can be
+// ranged over directly. Records that fail to decode are yielded as a
+// *DecodeError and iteration continues if the caller keeps consuming; any
+// other error ends the iteration.
+type StreamReader interface {
+	StreamVersionReports(ctx context.Context) func(yield func(VersionReport, error) bool)
+	StreamVersionReportV2Targets(ctx context.Context) func(yield func(VersionReportV2Target, error) bool)
+}
+
+// StreamVersionReports yields every V1 report recorded with the recorder
+// attached to ctx, or in the file at ENV_VAR_PREFIX, in the order they were
+// written. It yields ErrLocationNotSet if reporting is not configured.
+func StreamVersionReports(ctx context.Context) func(yield func(VersionReport, error) bool) {
+	return streamVersionReports(ctx, RecorderFromContext(ctx).reportSink())
+}
+
+func streamVersionReports(ctx context.Context, sink ReportSink) func(yield func(VersionReport, error) bool) {
+	if sink == nil {
+		return func(yield func(VersionReport, error) bool) {
+			yield(VersionReport{}, ErrLocationNotSet)
+		}
+	}
+	if streamSink, ok := sink.(StreamReader); ok {
+		return streamSink.StreamVersionReports(ctx)
+	}
+	return func(yield func(VersionReport, error) bool) {
+		reports, err := sink.ReadVersionReports(ctx)
+		yieldAll(reports, err, yield)
+	}
+}
+
+// StreamVersionReportV2Targets yields every V2 target recorded with the
+// recorder attached to ctx, or in the file derived from ENV_VAR_PREFIX, in the
+// order they were written. It yields nothing if reporting is not configured.
+func StreamVersionReportV2Targets(ctx context.Context) func(yield func(VersionReportV2Target, error) bool) {
+	return streamVersionReportV2Targets(ctx, RecorderFromContext(ctx).reportSink())
+}
+
+func streamVersionReportV2Targets(ctx context.Context, sink ReportSink) func(yield func(VersionReportV2Target, error) bool) {
+	if sink == nil {
+		return func(yield func(VersionReportV2Target, error) bool) {}
+	}
+	if streamSink, ok := sink.(StreamReader); ok {
+		return streamSink.StreamVersionReportV2Targets(ctx)
+	}
+	return func(yield func(VersionReportV2Target, error) bool) {
+		targets, err := sink.ReadVersionReportV2Targets(ctx)
+		yieldAll(targets, err, yield)
+	}
+}
+
+func yieldAll[T any](records []T, err error, yield func(T, error) bool) {
+	if err != nil {
+		var zero T
+		yield(zero, err)
+		return
+	}
+	for _, record := range records {
+		if !yield(record, nil) {
+			return
+		}
+	}
+}
+
+// collect drains seq. In lenient mode records that fail to decode are skipped
+// and described in the returned diagnostics; otherwise the first error is
+// returned along with the records before it.
+func collect[T any](seq func(yield func(T, error) bool), lenient bool) ([]T, []ReadDiagnostic, error) {
+	records := make([]T, 0)
+	var diagnostics []ReadDiagnostic
+	var err error
+	seq(func(record T, e error) bool {
+		var decodeErr *DecodeError
+		switch {
+		case e == nil:
+			records = append(records, record)
+		case lenient && errors.As(e, &decodeErr):
+			diagnostics = append(diagnostics, newReadDiagnostic(decodeErr))
+		default:
+			err = e
+			return false
+		}
+		return true
+	})
+	return records, diagnostics, err
+}
+
+// streamFile decodes the JSON lines in the file at location one at a time.
+// A missing file yields nothing if missingOK is set, and an error otherwise.
+func streamFile[T any](location string, kind ReportKind, missingOK bool, yield func(T, error) bool) {
+	var zero T
+
+	cursor, err := openRecordCursor[T](location, kind)
+	if err != nil {
+		if !(missingOK && os.IsNotExist(err)) {
+			yield(zero, err)
+		}
+		return
+	}
+	defer cursor.Close()
+
+	for {
+		record, _, err := cursor.next()
+		if err == io.EOF {
+			return
+		}
+		if !yield(record, err) {
+			return
+		}
+		if err != nil && !errors.Is(err, ErrCorruptReport) {
+			return
+		}
+	}
+}
+
+// recordCursor decodes the records of a report file one at a time.
+type recordCursor[T any] struct {
+	location string
+	kind     ReportKind
+	snapshot *snapshot
+	scanner  *lineScanner
+	// line is the raw line of the last record returned by next.
+	line []byte
+}
+
+func openRecordCursor[T any](location string, kind ReportKind) (*recordCursor[T], error) {
+	r, err := openSnapshot(location)
+	if err != nil {
+		return nil, err
+	}
+	return newRecordCursor[T](location, kind, r), nil
+}
+
+func newRecordCursor[T any](location string, kind ReportKind, r *snapshot) *recordCursor[T] {
+	return &recordCursor[T]{location: location, kind: kind, snapshot: r, scanner: newLineScanner(r)}
+}
+
+func (c *recordCursor[T]) Close() error {
+	return c.snapshot.Close()
+}
+
+// next decodes the next record and returns it with its envelope. It returns
+// io.EOF at the end of the file. After a *DecodeError the cursor can still be
+// advanced; any other error is final.
+func (c *recordCursor[T]) next() (T, envelope, error) {
+	var zero T
+	for {
+		line, err := c.scanner.next()
+		if err != nil {
+			return zero, envelope{}, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		record, env, err := decodeLine[T](line, c.location, c.kind, c.scanner)
+		if err != nil {
+			return zero, envelope{}, err
+		}
+		c.line = line
+		return record, env, nil
+	}
+}
+
+// decodeLine decodes the line last returned by scanner, which was read from
+// the file at location. Failures are returned as a *DecodeError.
+func decodeLine[T any](line []byte, location string, kind ReportKind, scanner *lineScanner) (T, envelope, error) {
+	var record T
+	payload, err := unframeRecord(line)
+	var env envelope
+	if err == nil {
+		env, err = decodeRecord(payload, kind, &record)
+	}
+	if err != nil {
+		decodeErr := &DecodeError{Path: location, Line: scanner.line, Offset: scanner.start, Kind: kind, Err: err}
+		decodeErr.snippet = snippet(line)
+		return record, envelope{}, decodeErr
+	}
+	return record, env, nil
+}
+
+// snapshot is a read-only view of a report file as it was when it was opened.
+type snapshot struct {
+	io.Reader
+	f *os.File
+}
+
+func (s *snapshot) Close() error {
+	return s.f.Close()
+}
+
+// openSnapshot opens the file at location for reading up to its current size.
+// The size is taken under a shared lock, so every record before it is
+// complete, and records appended afterwards are ignored. This lets large files
+// be streamed without blocking writers for the whole read.
+func openSnapshot(location string) (*snapshot, error) {
+	f, err := openLocked(location, os.O_RDONLY, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	unlockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &snapshot{Reader: io.LimitReader(f, info.Size()), f: f}, nil
+}
+
+// lineScanner reads newline-terminated lines of any length, reusing its buffer
+// between lines.
+type lineScanner struct {
+	r      *bufio.Reader
+	buf    []byte
+	line   int   // 1-based number of the last line returned
+	start  int64 // offset of the last line returned
+	offset int64 // offset of the next line
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	return &lineScanner{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// next returns the next line without its terminating newline. The line is only
+// valid until the following call. A final line without a newline is returned
+// as is; io.EOF is returned once there are no more lines.
+func (s *lineScanner) next() ([]byte, error) {
+	s.buf = s.buf[:0]
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		s.buf = append(s.buf, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(s.buf) > 0 {
+			err = nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	s.line++
+	s.start = s.offset
+	s.offset += int64(len(s.buf))
+	return bytes.TrimSuffix(s.buf, []byte{'\n'}), nil
+}
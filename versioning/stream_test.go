@@ -0,0 +1,273 @@
+package versioning
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamVersionReportV2Targets(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	v2 := `{"target_name":"typescript","new_version":"1.0.0"}
+{"target_name":"broken",
+{"target_name":"go","new_version":"2.0.0"}
+{"target_name":"python","new_version":"3.0.0"}
+`
+	require.NoError(t, os.WriteFile(v2LocationFor(location), []byte(v2), 0644))
+	ctx := WithDestination(context.Background(), location)
+
+	var names []string
+	var decodeErrs []*DecodeError
+	StreamVersionReportV2Targets(ctx)(func(target VersionReportV2Target, err error) bool {
+		if err != nil {
+			var decodeErr *DecodeError
+			require.ErrorAs(t, err, &decodeErr)
+			decodeErrs = append(decodeErrs, decodeErr)
+			return true
+		}
+		names = append(names, target.TargetName)
+		// Stop early; nothing else should be decoded.
+		return target.TargetName != "go"
+	})
+
+	assert.Equal(t, []string{"typescript", "go"}, names)
+	require.Len(t, decodeErrs, 1)
+	assert.Equal(t, 2, decodeErrs[0].Line)
+}
+
+func TestStreamVersionReports(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	var errs []error
+	StreamVersionReports(context.Background())(func(report VersionReport, err error) bool {
+		errs = append(errs, err)
+		return true
+	})
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], ErrLocationNotSet)
+
+	memory := NewMemorySink()
+	ctx := WithRecorder(context.Background(), NewRecorder(memory))
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "first"}))
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "second"}))
+
+	var keys []string
+	StreamVersionReports(ctx)(func(report VersionReport, err error) bool {
+		require.NoError(t, err)
+		keys = append(keys, report.Key)
+		return true
+	})
+	assert.Equal(t, []string{"first", "second"}, keys)
+}
+
+func TestStreamIgnoresRecordsAppendedDuringRead(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	ctx := WithDestination(context.Background(), location)
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "first"}))
+	require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "second"}))
+
+	var keys []string
+	StreamVersionReports(ctx)(func(report VersionReport, err error) bool {
+		require.NoError(t, err)
+		keys = append(keys, report.Key)
+		// Writers are not blocked while the file is being streamed.
+		require.NoError(t, AddVersionReport(ctx, VersionReport{Key: "late"}))
+		return true
+	})
+	assert.Equal(t, []string{"first", "second"}, keys)
+}
+
+func TestLineScanner(t *testing.T) {
+	long := strings.Repeat("x", 200*1024)
+	scanner := newLineScanner(strings.NewReader("a\n" + long + "\n\nlast"))
+
+	var lines []string
+	var offsets []int64
+	for {
+		line, err := scanner.next()
+		if err != nil {
+			break
+		}
+		lines = append(lines, string(line))
+		offsets = append(offsets, scanner.start)
+	}
+
+	assert.Equal(t, []string{"a", long, "", "last"}, lines)
+	assert.Equal(t, []int64{0, 2, int64(len(long)) + 3, int64(len(long)) + 4}, offsets)
+	assert.Equal(t, 4, scanner.line)
+}
+
+func writeBenchmarkTargets(b *testing.B, location string, count int) {
+	f, err := os.Create(v2LocationFor(location))
+	require.NoError(b, err)
+	defer f.Close()
+
+	sink := NewFileSink(location)
+	for i := 0; i < count; i++ {
+		target := VersionReportV2Target{TargetName: fmt.Sprint("target", i), NewVersion: "1.0.0"}
+		operation := VersionReportV2Operation{Name: "sdk.operation()", Type: OperationModified}
+		for j := 0; j < 50; j++ {
+			operation.Changes = append(operation.Changes, VersionReportV2FieldChange{
+				Path: fmt.Sprintf("request.body.field%d", j),
+				Type: FieldChanged,
+			})
+		}
+		target.Operations = append(target.Operations, operation)
+		require.NoError(b, sink.WriteVersionReportV2Target(context.Background(), target))
+	}
+}
+
+// peakHeap samples the live heap every few records while reading.
+type peakHeap struct {
+	n    int
+	peak uint64
+}
+
+func (p *peakHeap) sample() {
+	p.n++
+	if p.n%256 != 0 {
+		return
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > p.peak {
+		p.peak = stats.HeapAlloc
+	}
+}
+
+// Compare the peak-heap metric across sizes: it stays flat when streaming and
+// grows with the file when reading everything at once.
+func BenchmarkReadVersionReportV2Targets(b *testing.B) {
+	for _, count := range []int{1000, 10000, 50000} {
+		location := filepath.Join(b.TempDir(), "version.json")
+		writeBenchmarkTargets(b, location, count)
+		sink := NewFileSink(location)
+
+		b.Run(fmt.Sprint("stream/records=", count), func(b *testing.B) {
+			var heap peakHeap
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				sink.StreamVersionReportV2Targets(context.Background())(func(target VersionReportV2Target, err error) bool {
+					heap.sample()
+					return err == nil
+				})
+			}
+			b.ReportMetric(float64(heap.peak), "peak-heap-bytes")
+		})
+
+		b.Run(fmt.Sprint("read/records=", count), func(b *testing.B) {
+			var heap peakHeap
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				targets, err := sink.ReadVersionReportV2Targets(context.Background())
+				require.NoError(b, err)
+				for range targets {
+					heap.sample()
+				}
+			}
+			b.ReportMetric(float64(heap.peak), "peak-heap-bytes")
+		})
+	}
+}
+
+func BenchmarkMergedVersionReport(b *testing.B) {
+	for _, count := range []int{1000, 10000, 100000} {
+		location := filepath.Join(b.TempDir(), "version.json")
+		sink := NewFileSink(location)
+		for i := 0; i < count; i++ {
+			// Few distinct keys, so the merged view stays small as the file grows.
+			report := VersionReport{Key: fmt.Sprint("key", i%10), PRReport: strings.Repeat("x", 1024)}
+			require.NoError(b, sink.WriteVersionReport(context.Background(), report))
+		}
+
+		b.Run(fmt.Sprint("records=", count), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				merged, err := readMergedVersionReport(context.Background(), sink, nil)
+				require.NoError(b, err)
+				require.Len(b, merged.Reports, 10)
+			}
+		})
+	}
+}
+
+// heapSampler samples the heap for every V1 report streamed from, or written
+// to, the file sink it wraps.
+type heapSampler struct {
+	*FileSink
+	heap *peakHeap
+}
+
+func (s heapSampler) WriteVersionReport(ctx context.Context, report VersionReport) error {
+	s.heap.sample()
+	return s.FileSink.WriteVersionReport(ctx, report)
+}
+
+func (s heapSampler) StreamVersionReports(ctx context.Context) func(yield func(VersionReport, error) bool) {
+	return func(yield func(VersionReport, error) bool) {
+		s.FileSink.StreamVersionReports(ctx)(func(report VersionReport, err error) bool {
+			s.heap.sample()
+			return yield(report, err)
+		})
+	}
+}
+
+// The peak heap of reads and captures stays flat as the file grows, unless the
+// history of every key is kept.
+func BenchmarkRecorderRead(b *testing.B) {
+	for _, count := range []int{1000, 10000, 50000} {
+		location := filepath.Join(b.TempDir(), "version.json")
+		sink := NewFileSink(location)
+		for i := 0; i < count; i++ {
+			report := VersionReport{Key: fmt.Sprint("key", i%10), PRReport: strings.Repeat("x", 1024)}
+			require.NoError(b, sink.WriteVersionReport(context.Background(), report))
+		}
+
+		for name, opts := range map[string][]CaptureOption{"merged": nil, "history": {WithHistory()}} {
+			b.Run(fmt.Sprintf("%s/records=%d", name, count), func(b *testing.B) {
+				var heap peakHeap
+				recorder := NewRecorder(heapSampler{FileSink: sink, heap: &heap})
+				for i := 0; i < b.N; i++ {
+					runtime.GC()
+					capture, err := recorder.Read(context.Background(), opts...)
+					require.NoError(b, err)
+					require.Len(b, capture.V1.Reports, 10)
+				}
+				b.ReportMetric(float64(heap.peak), "peak-heap-bytes")
+			})
+		}
+	}
+}
+
+func BenchmarkCapture(b *testing.B) {
+	for _, count := range []int{1000, 10000} {
+		b.Run(fmt.Sprint("records=", count), func(b *testing.B) {
+			var heap peakHeap
+			// The capture is forwarded to a file, so it is read back from one.
+			parent := NewFileSink(filepath.Join(b.TempDir(), "version.json"))
+			recorder := &Recorder{sink: heapSampler{FileSink: parent, heap: &heap}, destination: destinationOf(parent)}
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				capture, err := recorder.Capture(context.Background(), func(ctx context.Context) error {
+					for i := 0; i < count; i++ {
+						report := VersionReport{Key: fmt.Sprint("key", i%10), PRReport: strings.Repeat("x", 1024)}
+						if err := AddVersionReport(ctx, report); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				require.NoError(b, err)
+				require.Len(b, capture.V1.Reports, 10)
+			}
+			b.ReportMetric(float64(heap.peak), "peak-heap-bytes")
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/speakeasy-api/versioning-reports/versioning"
+)
+
+// Appends a batch of large V1 and V2 records so that the parent test can
+// check that concurrent writers never tear or lose a line.
+func main() {
+	ctx := context.Background()
+	writer := os.Args[1]
+	count, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		log.Fatal(err)
+	}
+	size, err := strconv.Atoi(os.Args[3])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("writer%s-%d", writer, i)
+		err := versioning.AddVersionReport(ctx, versioning.VersionReport{
+			Key:      key,
+			PRReport: strings.Repeat(key, size/len(key)+1)[:size],
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = versioning.AddVersionReportV2Target(ctx, versioning.VersionReportV2Target{
+			TargetName:  key,
+			PackageName: strings.Repeat(key, size/len(key)+1)[:size],
+			NewVersion:  "1.0.0",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
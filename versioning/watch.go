@@ -0,0 +1,289 @@
+package versioning
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"os"
+	"time"
+)
+
+// WatchEvent carries one record as it is written, or an error met while
+// following the records.
+type WatchEvent struct {
+	Kind   ReportKind
+	Report VersionReport         // set if Kind is ReportKindV1
+	Target VersionReportV2Target // set if Kind is ReportKindV2
+	// Err is set instead of a record. A *DecodeError reports a record that
+	// could not be decoded; watching continues after any error.
+	Err error
+}
+
+// Watcher is implemented by sinks whose records can be followed as they are
+// written: FileSink, DirSink and MemorySink.
+type Watcher interface {
+	// Watch sends every record of the sink on the returned channel, starting
+	// with those already written, until ctx is cancelled. The channel is
+	// closed afterwards.
+	Watch(ctx context.Context, opts ...WatchOption) <-chan WatchEvent
+}
+
+// WatchOption configures Watch.
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	interval time.Duration
+}
+
+// WithPollInterval sets how often report files are checked for new records.
+// It defaults to 100ms.
+func WithPollInterval(interval time.Duration) WatchOption {
+	return func(o *watchOptions) {
+		o.interval = interval
+	}
+}
+
+func newWatchOptions(opts []WatchOption) watchOptions {
+	options := watchOptions{interval: 100 * time.Millisecond}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// Watch follows the records written with the recorder attached to ctx, or to
+// the files at ENV_VAR_PREFIX, until ctx is cancelled. Inside
+// WithVersionReportCapture it follows the records of the capture, which lets
+// callers show progress while f is still running.
+func Watch(ctx context.Context, opts ...WatchOption) (<-chan WatchEvent, error) {
+	sink := RecorderFromContext(ctx).reportSink()
+	if sink == nil {
+		return nil, ErrLocationNotSet
+	}
+	if multi, ok := sink.(*MultiSink); ok && len(multi.sinks) > 0 {
+		sink = multi.sinks[0]
+	}
+	watcher, ok := sink.(Watcher)
+	if !ok {
+		return nil, ErrWatchNotSupported
+	}
+	return watcher.Watch(ctx, opts...), nil
+}
+
+// send delivers event unless ctx is cancelled first.
+func send(ctx context.Context, events chan<- WatchEvent, event WatchEvent) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Watch follows the V1 and V2 files, which do not need to exist yet. If a file
+// is replaced, for example by Compact, it is followed again from its start,
+// skipping the records that survived the compaction, which were already sent.
+func (s *FileSink) Watch(ctx context.Context, opts ...WatchOption) <-chan WatchEvent {
+	var tailers []*tailer
+	if len(s.Location) > 0 {
+		tailers = append(tailers, &tailer{location: s.Location, kind: ReportKindV1})
+	}
+	if len(s.V2Location) > 0 {
+		tailers = append(tailers, &tailer{location: s.V2Location, kind: ReportKindV2})
+	}
+	return watchFiles(ctx, newWatchOptions(opts), func() ([]*tailer, error) {
+		return tailers, nil
+	})
+}
+
+// Watch follows every shard in the directory, including shards created after
+// watching started. Records are sent in the order they are found, which is
+// only guaranteed to match the order they were written in within a shard.
+func (s *DirSink) Watch(ctx context.Context, opts ...WatchOption) <-chan WatchEvent {
+	tailers := make(map[string]*tailer)
+	var ordered []*tailer
+	return watchFiles(ctx, newWatchOptions(opts), func() ([]*tailer, error) {
+		for _, kind := range []ReportKind{ReportKindV1, ReportKindV2} {
+			suffix := v1ShardSuffix
+			if kind == ReportKindV2 {
+				suffix = v2ShardSuffix
+			}
+			shards, err := s.shards(suffix)
+			if err != nil {
+				return ordered, err
+			}
+			for _, shard := range shards {
+				if _, ok := tailers[shard]; !ok {
+					tailers[shard] = &tailer{location: shard, kind: kind}
+					ordered = append(ordered, tailers[shard])
+				}
+			}
+		}
+		return ordered, nil
+	})
+}
+
+// watchFiles polls the files returned by list until ctx is cancelled.
+func watchFiles(ctx context.Context, options watchOptions, list func() ([]*tailer, error)) <-chan WatchEvent {
+	events := make(chan WatchEvent)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(options.interval)
+		defer ticker.Stop()
+
+		emit := func(event WatchEvent) bool {
+			return send(ctx, events, event)
+		}
+		for {
+			tailers, err := list()
+			if err != nil && !emit(WatchEvent{Err: err}) {
+				return
+			}
+			for _, t := range tailers {
+				if !t.poll(emit) {
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return events
+}
+
+// tailer follows the records appended to one report file.
+type tailer struct {
+	location string
+	kind     ReportKind
+
+	file   os.FileInfo // the file being followed
+	offset int64       // start of the first line not sent yet
+	line   int         // number of lines before offset
+
+	// sent holds a hash of every line sent from the file being followed.
+	sent map[uint64]struct{}
+	// replaced holds those of the file it replaced while the start of the
+	// new one is skipped. Compactions copy lines verbatim and in order, so
+	// the lines that were already sent come first.
+	replaced map[uint64]struct{}
+}
+
+// poll sends the complete lines appended since the last poll. A final line
+// without its newline is still being written, and is left for a later poll.
+// It returns false once emit does.
+func (t *tailer) poll(emit func(WatchEvent) bool) bool {
+	f, err := openLocked(t.location, os.O_RDONLY, 0, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true
+		}
+		return emit(WatchEvent{Kind: t.kind, Err: err})
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	unlockFile(f)
+	if err != nil {
+		return emit(WatchEvent{Kind: t.kind, Err: err})
+	}
+
+	if t.file != nil && (!os.SameFile(t.file, info) || info.Size() < t.offset) {
+		t.offset, t.line = 0, 0
+		t.replaced, t.sent = t.sent, nil
+	}
+	if t.sent == nil {
+		t.sent = make(map[uint64]struct{})
+	}
+	t.file = info
+	if info.Size() == t.offset {
+		return true
+	}
+
+	scanner := newLineScanner(io.NewSectionReader(f, t.offset, info.Size()-t.offset))
+	scanner.offset, scanner.line = t.offset, t.line
+	for {
+		line, err := scanner.next()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return emit(WatchEvent{Kind: t.kind, Err: err})
+		}
+		if scanner.offset-scanner.start == int64(len(line)) {
+			// No newline yet.
+			return true
+		}
+		t.offset, t.line = scanner.offset, scanner.line
+		if len(line) == 0 {
+			continue
+		}
+		hash := fnv.New64a()
+		hash.Write(line)
+		sum := hash.Sum64()
+		t.sent[sum] = struct{}{}
+		if _, ok := t.replaced[sum]; ok {
+			continue
+		}
+		t.replaced = nil
+
+		event := WatchEvent{Kind: t.kind}
+		if t.kind == ReportKindV1 {
+			event.Report, _, event.Err = decodeLine[VersionReport](line, t.location, t.kind, scanner)
+		} else {
+			event.Target, _, event.Err = decodeLine[VersionReportV2Target](line, t.location, t.kind, scanner)
+		}
+		if !emit(event) {
+			return false
+		}
+	}
+}
+
+// Watch sends every record written to the sink, waking up as soon as one is.
+func (s *MemorySink) Watch(ctx context.Context, opts ...WatchOption) <-chan WatchEvent {
+	events := make(chan WatchEvent)
+	go func() {
+		defer close(events)
+		var sentReports, sentTargets int
+		for {
+			s.mu.Lock()
+			reports := s.reports[sentReports:]
+			targets := s.targets[sentTargets:]
+			if s.changed == nil {
+				s.changed = make(chan struct{})
+			}
+			changed := s.changed
+			s.mu.Unlock()
+
+			for _, report := range reports {
+				if !send(ctx, events, WatchEvent{Kind: ReportKindV1, Report: report}) {
+					return
+				}
+			}
+			for _, target := range targets {
+				if !send(ctx, events, WatchEvent{Kind: ReportKindV2, Target: target}) {
+					return
+				}
+			}
+			sentReports += len(reports)
+			sentTargets += len(targets)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+		}
+	}()
+	return events
+}
+
+// notifyLocked wakes up the watchers of s. The caller holds s.mu.
+func (s *MemorySink) notifyLocked() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
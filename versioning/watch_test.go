@@ -0,0 +1,168 @@
+package versioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nextEvent(t *testing.T, events <-chan WatchEvent) WatchEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "the channel was closed")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return WatchEvent{}
+	}
+}
+
+func TestWatchFileSink(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := sink.Watch(ctx, WithPollInterval(10*time.Millisecond))
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test1", PRReport: "Test report 1"}))
+	event := nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, ReportKindV1, event.Kind)
+	assert.Equal(t, "test1", event.Report.Key)
+
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go", NewVersion: "1.0.0"}))
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, ReportKindV2, event.Kind)
+	assert.Equal(t, "go", event.Target.TargetName)
+
+	// A line is only sent once its newline has been written.
+	f, err := os.OpenFile(location, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(`{"key":"test2",`)
+	require.NoError(t, err)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event for a partial line: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = f.WriteString(`"pr_report":"Test report 2"}` + "\n")
+	require.NoError(t, err)
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, "Test report 2", event.Report.PRReport)
+
+	_, err = f.WriteString("not json\n")
+	require.NoError(t, err)
+	event = nextEvent(t, events)
+	var decodeErr *DecodeError
+	require.ErrorAs(t, event.Err, &decodeErr)
+	assert.Equal(t, 3, decodeErr.Line)
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test3"}))
+	event = nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, "test3", event.Report.Key)
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestWatchSendsExistingRecords(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewDirSink(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "existing"}))
+	events := sink.Watch(ctx, WithPollInterval(10*time.Millisecond))
+	assert.Equal(t, "existing", nextEvent(t, events).Report.Key)
+
+	// Shards of other processes are picked up as they appear.
+	other := &FileSink{Location: filepath.Join(dir, "otherhost-1"+v1ShardSuffix)}
+	require.NoError(t, other.WriteVersionReport(ctx, VersionReport{Key: "other"}))
+	assert.Equal(t, "other", nextEvent(t, events).Report.Key)
+}
+
+func TestWatchMemorySink(t *testing.T) {
+	sink := NewMemorySink()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "test1"}))
+	events := sink.Watch(ctx)
+	assert.Equal(t, "test1", nextEvent(t, events).Report.Key)
+
+	require.NoError(t, sink.WriteVersionReportV2Target(ctx, VersionReportV2Target{TargetName: "go"}))
+	assert.Equal(t, "go", nextEvent(t, events).Target.TargetName)
+
+	cancel()
+	_, open := <-events
+	assert.False(t, open)
+}
+
+func TestWatchDuringCapture(t *testing.T) {
+	os.Unsetenv(ENV_VAR_PREFIX)
+
+	capture, _, err := WithVersionReportCapture(context.Background(), func(ctx context.Context) (bool, error) {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events, err := Watch(watchCtx, WithPollInterval(10*time.Millisecond))
+		require.NoError(t, err)
+
+		for _, key := range []string{"typescript", "go"} {
+			require.NoError(t, AddVersionReport(ctx, VersionReport{Key: key, BumpType: BumpMinor}))
+			event := nextEvent(t, events)
+			require.NoError(t, event.Err)
+			assert.Equal(t, key, event.Report.Key)
+		}
+		return true, nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, capture.V1.Reports, 2)
+}
+
+func TestWatchNotSupported(t *testing.T) {
+	ctx := WithRecorder(context.Background(), NewRecorder(NewSocketSink("/nonexistent.sock")))
+	_, err := Watch(ctx)
+	assert.ErrorIs(t, err, ErrWatchNotSupported)
+
+	_, err = Watch(WithRecorder(context.Background(), &Recorder{}))
+	assert.ErrorIs(t, err, ErrLocationNotSet)
+}
+
+func TestWatchAfterCompaction(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "version.json")
+	sink := NewFileSink(location)
+	sink.CompactThreshold = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := sink.Watch(ctx, WithPollInterval(10*time.Millisecond))
+	for _, key := range []string{"sdk", "docs", "sdk", "docs", "python"} {
+		require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: key, PRReport: key}))
+		event := nextEvent(t, events)
+		require.NoError(t, event.Err)
+		assert.Equal(t, key, event.Report.Key)
+	}
+
+	// Every write replaced the file, but only new records are sent.
+	require.NoError(t, sink.WriteVersionReport(ctx, VersionReport{Key: "sdk", PRReport: "last"}))
+	event := nextEvent(t, events)
+	require.NoError(t, event.Err)
+	assert.Equal(t, "last", event.Report.PRReport)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected event for a record sent before: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}